@@ -0,0 +1,314 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/osc-em/oscem-extractor-life/internal/configuration"
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+)
+
+func configUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: oscem-extractor-life config <command> [options] [arguments]
+
+Commands:
+  show [--effective]             print the config file, or every value with the source it comes from
+  get <key>                      print the value of a key as it is used for the extraction
+  set <key>=<value> ...          validate and store values in the config file
+  unset <key> ...                remove values from the config file
+  import <file>                  validate a config file and replace the current one with it
+  export [-o <file>]             print the config file (or write it to file)
+  validate                       check the values of the config file and its profiles
+
+Options (before the arguments):
+  --config <file>                use this config file instead of the one in the user config directory
+  --profile <name>               get/set/unset the values of an instrument profile`)
+}
+
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		configUsage()
+		return 2
+	}
+	switch args[0] {
+	case "show":
+		return configShow(args[1:])
+	case "get":
+		return configGet(args[1:])
+	case "set":
+		return configSet(args[1:])
+	case "unset":
+		return configUnset(args[1:])
+	case "import":
+		return configImport(args[1:])
+	case "export":
+		return configExport(args[1:])
+	case "validate":
+		return configValidate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown config command %q\n", args[0])
+		configUsage()
+		return 2
+	}
+}
+
+// configFlags sets up the flags shared by all config commands
+func configFlags(command string) (*flag.FlagSet, *string, *string) {
+	flags := flag.NewFlagSet("config "+command, flag.ContinueOnError)
+	path := flags.String("config", "", "Path of the config file, default is the user config directory")
+	profile := flags.String("profile", "", "Name of an instrument profile")
+	return flags, path, profile
+}
+
+// resolvedPath is the config file used, the default one if none was given
+func resolvedPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	return configuration.DefaultPath()
+}
+
+func configGet(args []string) int {
+	flags, path, profile := configFlags("get")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: oscem-extractor-life config get [--config <file>] [--profile <name>] <key>")
+		return 2
+	}
+	config, err := configuration.Load(*path, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Your config was unretrievable:", err)
+		return 1
+	}
+	if *profile != "" {
+		if err := config.UseProfile(*profile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	value, exists := config.Lookup(flags.Arg(0))
+	if !exists {
+		fmt.Fprintln(os.Stderr, flags.Arg(0), "is not set")
+		return 1
+	}
+	fmt.Println(value.Value)
+	return 0
+}
+
+func configSet(args []string) int {
+	flags, path, profile := configFlags("set")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: oscem-extractor-life config set [--config <file>] [--profile <name>] <key>=<value> ...")
+		return 2
+	}
+	file, err := resolvedPath(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't reach config directory", err)
+		return 1
+	}
+	// check all assignments first, so either all or none are written
+	for _, assignment := range flags.Args() {
+		name, value, found := strings.Cut(assignment, "=")
+		if !found {
+			fmt.Fprintf(os.Stderr, "%q is not of the form key=value\n", assignment)
+			return 2
+		}
+		if err := configuration.ValidateValue(name, value); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	for _, assignment := range flags.Args() {
+		name, value, _ := strings.Cut(assignment, "=")
+		if err := configuration.SetValue(file, *profile, name, value); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the config:", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func configUnset(args []string) int {
+	flags, path, profile := configFlags("unset")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: oscem-extractor-life config unset [--config <file>] [--profile <name>] <key> ...")
+		return 2
+	}
+	file, err := resolvedPath(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't reach config directory", err)
+		return 1
+	}
+	for _, name := range flags.Args() {
+		if err := configuration.UnsetValue(file, *profile, name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func configImport(args []string) int {
+	flags, path, _ := configFlags("import")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: oscem-extractor-life config import [--config <file>] <file>")
+		return 2
+	}
+	file, err := resolvedPath(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't reach config directory", err)
+		return 1
+	}
+	if err := configuration.Import(file, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("Imported config to", file)
+	return 0
+}
+
+func configExport(args []string) int {
+	flags, path, _ := configFlags("export")
+	output := flags.String("o", "", "Write the config to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, err := resolvedPath(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't reach config directory", err)
+		return 1
+	}
+	content, err := configuration.Export(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Your config was unretrievable:", err)
+		return 1
+	}
+	if *output != "" {
+		err = os.WriteFile(*output, append(content, '\n'), 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the config:", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Println(string(content))
+	return 0
+}
+
+func configValidate(args []string) int {
+	flags, path, _ := configFlags("validate")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, err := resolvedPath(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't reach config directory", err)
+		return 1
+	}
+	errs := configuration.Validate(file)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Println(file, "is valid")
+	return 0
+}
+
+// selectProfile applies the forced profile, or picks the one matching the instrument of the dataset
+func selectProfile(config *configuration.Config, forced string, directory string, folderRegex string) error {
+	if forced != "" {
+		return config.UseProfile(forced)
+	}
+	if config.Profile != "" || len(config.Profiles) == 0 {
+		return nil
+	}
+	// the mirror may be below the MPCPATH of any of the instruments
+	rules := config.PathMappings
+	for _, profile := range config.Profiles {
+		if profile.Values["MPCPATH"] != "" {
+			rules = append(rules, pathmap.RootRule(profile.Values["MPCPATH"]))
+		}
+	}
+	if config.Get("MPCPATH") != "" {
+		rules = append(rules, pathmap.RootRule(config.Get("MPCPATH")))
+	}
+	sample, err := metadataparser.SampleMetadata(directory, rules, folderRegex)
+	if err != nil {
+		return fmt.Errorf("could not read a metadata file to select an instrument profile: %w", err)
+	}
+	name, matchErr := config.MatchProfile(sample)
+	if name == "" {
+		return fmt.Errorf("no instrument profile matches the data, using the general config")
+	}
+	if err := config.UseProfile(name); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Using instrument profile", name)
+	return matchErr
+}
+
+func configShow(args []string) int {
+	flags, path, profile := configFlags("show")
+	effective := flags.Bool("effective", false, "Show the merged value of every key and where it comes from (flag, env, file or default)")
+	// the extraction flags, to preview their effect
+	for _, key := range configuration.Keys {
+		flags.String(key.Flag, "", key.Description)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	config, err := configuration.Load(*path, setFlags(flags))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Your config was unretrievable:", err)
+		return 1
+	}
+	if *profile != "" {
+		if err := config.UseProfile(*profile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if !*effective {
+		content, err := os.ReadFile(config.Path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "No config file at", config.Path, err)
+			return 1
+		}
+		fmt.Println(string(content))
+		return 0
+	}
+	fmt.Println("config file:", config.Path)
+	if len(config.Profiles) > 0 {
+		var names []string
+		for name := range config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("instrument profiles:", strings.Join(names, ", "))
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tVALUE\tSOURCE")
+	for _, value := range config.Effective() {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", value.Key, value.Value, value.Source)
+	}
+	writer.Flush()
+	return 0
+}
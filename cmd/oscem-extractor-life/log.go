@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+)
+
+// logFlags are the logging and strict mode flags shared by the commands
+type logFlags struct {
+	level           *string
+	format          *string
+	strict          *bool
+	maxFailureRatio *float64
+}
+
+func addLogFlags(flags *flag.FlagSet) *logFlags {
+	return &logFlags{
+		level:           flags.String("log_level", "info", "Log messages of this level and above to stderr: debug, info, warn or error"),
+		format:          flags.String("log_format", "text", "Format of the log messages: text or json"),
+		strict:          flags.Bool("strict", false, "Fail the extraction if a metadata file could not be read (see --max_failure_ratio)"),
+		maxFailureRatio: flags.Float64("max_failure_ratio", 0, "With --strict, the fraction (0-1) of metadata files that may fail before the extraction fails"),
+	}
+}
+
+// apply sets up the logger, also as slog default, and the strict mode of opts
+func (l *logFlags) apply(opts *metadataparser.Options) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*l.level)); err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", *l.level)
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(*l.format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, handlerOptions)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, handlerOptions)
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", *l.format)
+	}
+	if *l.maxFailureRatio < 0 || *l.maxFailureRatio > 1 {
+		return fmt.Errorf("--max_failure_ratio must be between 0 and 1")
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	opts.Logger = logger
+	opts.Strict = *l.strict
+	opts.MaxFailureRatio = *l.maxFailureRatio
+	return nil
+}
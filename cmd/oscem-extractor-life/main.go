@@ -1,10 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/osc-em/oscem-extractor-life/internal/configuration"
 	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
@@ -12,7 +18,29 @@ import (
 	conversion "github.com/osc-em/oscem-converter-extracted"
 )
 
+// setFlags returns the values of the flags that were given on the command line
+func setFlags(flags *flag.FlagSet) map[string]string {
+	set := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	return set
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		os.Exit(runWatch(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServe(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == convertCommand {
+		os.Exit(runConvert(os.Args[2:]))
+	}
+
 	//for benchmarking
 	/*f, err := os.Create("trace.out")
 	if err != nil {
@@ -24,29 +52,64 @@ func main() {
 	}
 	defer trace.Stop()*/
 
-	create_zip := flag.Bool("z", false, "Toggle whether to make a zip archive of all xml files - default: false")
+	create_zip := flag.Bool("z", false, "Toggle whether to make an archive of all xml, mdoc and session files (xmls.zip in the working directory) - default: false")
+	zip_out := flag.String("zip-out", "", "Provide a path for the archive of the metadata files, implies -z; a .tar.gz or .tgz extension selects tar.gz")
+	archive_format := flag.String("archive_format", "", "Format of the archive: zip or tar.gz, empty to take it from the extension of --zip-out")
+	compression_level := flag.Int("compression_level", 0, "Compression level of the archive from 1 (fastest) to 9 (smallest), 0 for the default")
 	write_full_metadata := flag.Bool("f", false, "Toggle whether the full metadata is also written out in addition to the OSCEM schema conform one- default: false")
+	full_out := flag.String("full_out", "", "Provide a path for the full metadata, implies -f; - writes it to stdout. Default: <output>_full.json next to -o, or <folder>_full.json in the working directory")
+	force := flag.Bool("force", false, "Overwrite an existing full metadata file")
+	xml_types := flag.Bool("xml_types", false, "Keep the i:type of the EPU CustomData entries as <key>.@type in the full metadata")
+	cache := flag.Bool("cache", false, "Keep the parsed metadata files in the user cache directory, so the next run only parses new and changed files")
+	cache_dir := flag.String("cache_dir", "", "Keep the cache in this directory instead, implies --cache")
 	reset_config_file := flag.Bool("c", false, "If you want to reset your config file")
+	config_file := flag.String("config", "", "Provide the path of a config file to use instead of the one in the user config directory")
 	output_file_path := flag.String("o", "", "Provide target output path and name for your metadata file, leave empty to write to current working directory")
 	input_folder_path := flag.String("i", "", "Provide target input folder - will take first positional argument if --i is missing")
 	cs_value := flag.String("cs", "", "Provide CS value here, if you dont want to use configs")
-	gain_flip_rotate := flag.String("gain_flip_rotate", "", "Provide whether and how to flip the gain ref here, if you dont want to use configs")
-	epu_folder := flag.String("epu", "", "Provide the path to the mirrored EPU folder containing all the xmls of the datacollections here, if you dont want to use configs")
+	profile := flag.String("profile", "", "Use this instrument profile of the config instead of selecting one from the data")
+	// like cs, these are resolved through the config, see resolveSettings
+	flag.String("gain_flip_rotate", "", "Provide how to rotate/flip the gain ref here, if you dont want to use configs or derive it from the data: e.g. flipx, rotate90, relion:gain_rot=1,gain_flip=0, cryosparc:flip_x=1,flip_y=0,rotate=0, imod:5")
+	flag.String("epu", "", "Provide the path to the mirrored EPU folder containing all the xmls of the datacollections here, if you dont want to use configs")
+	explain_paths := flag.Bool("explain-paths", false, "Print which EPU mirror folders were tried for the dataset, which metadata folders were searched and which referenced movies are missing")
 	metadataFolder := flag.String("folder_filter", "", "If the system deviates from standard EPU naming conventions, a regex for the folder name with the metadata files can be provided.")
 	print_to_stdout := flag.Bool("cli_out", false, "If you want the results also as a stdout")
+	timeline_csv := flag.String("timeline_csv", "", "Provide a path to also write the session timeline (hourly throughput and acquisition gaps) as csv")
+	quality_report := flag.String("quality_report", "", "Provide a path to write a json report of the data quality checks")
+	quality_rules := flag.String("quality_rules", "", "Provide a json file with additional or overriding quality rules")
+	fail_on_error := flag.Bool("fail_on_error", false, "Exit with a non-zero code and skip the output if a quality rule with severity error matched")
+	report := flag.String("report", "", "Provide a path to also write a self-contained html session report")
+	stage_map := flag.String("stage_map", "", "Provide a path to write an svg map of all acquisition positions on the grid")
+	shift_overlay := flag.Bool("shift_overlay", false, "Mark the beam-image shift cluster of every target on the stage map")
+	optics_groups := flag.String("optics_groups", "", "Assign optics groups by clustering the beam-image shifts: grid or kmeans")
+	optics_groups_k := flag.Int("optics_groups_k", 0, "Number of optics groups for kmeans, 0 to take the number of shift positions found")
+	optics_groups_radius := flag.Float64("optics_groups_radius", 0, "Maximal distance of a shift to its group centre (shift units), 0 for automatic")
+	optics_groups_csv := flag.String("optics_groups_csv", "", "Provide a path to write the optics group of every movie as csv")
+	optics_groups_star := flag.String("optics_groups_star", "", "Provide a path to write the optics groups as RELION star file")
+	amplitude_contrast := flag.Float64("amplitude_contrast", 0.1, "Amplitude contrast of the optics table in the star file")
+	completeness_report := flag.String("completeness", "", "Provide a path to write a json report pairing every metadata file with its movie, listing missing and orphaned movies")
+	min_completeness := flag.Float64("min_completeness", 0, "Skip the output and exit with a non-zero code if a smaller fraction (0-1) of the metadata files has its movie")
+	manifest_file := flag.String("manifest", "", "Provide a path to write the size, modification time and checksum of all metadata and movie files")
+	manifest_format := flag.String("manifest_format", "csv", "Format of the manifest: csv, bagit (manifest-<checksum>.txt) or scicat (OrigDatablock)")
+	manifest_checksum := flag.String("manifest_checksum", "sha256", "Checksum of the manifest: sha256 or xxh64")
+	manifest_workers := flag.Int("manifest_workers", 4, "Number of files checksummed in parallel")
+	progress := flag.String("progress", "", progressUsage)
+	logging := addLogFlags(flag.CommandLine)
+	workers := flag.Int("workers", 0, "Number of metadata files read in parallel, 0 for the number of CPUs; more help on network mounts")
+	file_timeout := flag.Duration("file_timeout", 0, "Give up a metadata file that takes longer to read, e.g. on a stalled network mount; 0 for no limit")
+	deadline := flag.Duration("deadline", 0, "Stop reading the metadata files after this long and write the incomplete results, 0 for no limit")
+	gap_threshold := flag.Duration("gap_threshold", 10*time.Minute, "Minimum pause between two movies that is reported as an acquisition gap")
 	flag.Parse()
 	posArgs := flag.Args()
 
 	// allow for reconfiguration of the config
 	if *reset_config_file {
-		current, err := configuration.Getconfig()
-		var grid map[string]string
+		current, err := configuration.Getconfig(*config_file)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, " No prior config obtainable", err)
 		}
-		_ = json.Unmarshal(current, &grid)
-		fmt.Println("current config:\n", grid)
-		configuration.Changeconfig()
+		fmt.Println("current config:\n", string(current))
+		configuration.Changeconfig(*config_file)
 		return
 	}
 	var directory string
@@ -60,26 +123,113 @@ func main() {
 		directory = posArgs[0]
 	}
 
-	current, err := configuration.Getconfig()
-	var grid map[string]string
-	if err == nil && *cs_value == "" && *gain_flip_rotate == "" && *epu_folder == "" {
-		_ = json.Unmarshal(current, &grid)
-		*cs_value = grid["cs"]
-		*gain_flip_rotate = grid["gainref_flip_rotate"]
-		*epu_folder = grid["MPCPATH"]
+	cs, opts, err := resolveSettings(flag.CommandLine, *config_file, *profile, directory, *metadataFolder)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	*cs_value = cs
+	opts.OnProgress, err = progressPrinter(*progress, os.Stderr)
+	if err == nil {
+		err = logging.apply(&opts)
 	}
-
-	data, err := metadataparser.ReadMetadata(directory, *create_zip, *write_full_metadata, *epu_folder, *metadataFolder)
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	opts.CreateZip = *create_zip
+	opts.ZipOut = *zip_out
+	opts.ArchiveFormat = *archive_format
+	opts.CompressionLevel = *compression_level
+	opts.WriteFullMetadata = *write_full_metadata
+	opts.FullMetadataOut = fullMetadataPath(*full_out, *output_file_path, *write_full_metadata)
+	opts.Force = *force
+	opts.XMLTypes = *xml_types
+	opts.Cache = *cache
+	opts.CacheDir = *cache_dir
+	opts.TimelineCSV = *timeline_csv
+	opts.GapThreshold = *gap_threshold
+	opts.QualityRules = *quality_rules
+	opts.QualityReport = *quality_report
+	opts.FailOnQualityErrors = *fail_on_error
+	opts.OpticsGroups = *optics_groups
+	opts.OpticsGroupsK = *optics_groups_k
+	opts.OpticsGroupsRadius = *optics_groups_radius
+	opts.ExplainPaths = *explain_paths
+	opts.CompletenessReport = *completeness_report
+	opts.MinCompleteness = *min_completeness
+	opts.Manifest = *manifest_file
+	opts.ManifestFormat = *manifest_format
+	opts.ManifestChecksum = *manifest_checksum
+	opts.ManifestWorkers = *manifest_workers
+	opts.FileTimeout = *file_timeout
+	opts.Workers = *workers
+
+	// the first SIGINT or SIGTERM stops reading and writes what was read so far, a second one ends at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	result, err := metadataparser.ExtractContext(ctx, directory, opts)
+	incomplete := result != nil && result.Incomplete
+	if incomplete {
+		fmt.Fprintln(os.Stderr, "The extraction was interrupted, the outputs are incomplete:", err)
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, "The extraction went wrong due to", err)
+		if errors.Is(err, fs.ErrExist) {
+			fmt.Fprintln(os.Stderr, "Use --force to overwrite it or --full_out to choose another path")
+		}
 		os.Exit(1)
 	}
-	out, err1 := conversion.Convert(data, "", *cs_value, *gain_flip_rotate, *output_file_path)
+	out, err1 := conversion.Convert(result.Metadata, "", *cs_value, result.GainFlipRotate, *output_file_path)
 	if err1 != nil {
 		fmt.Fprintln(os.Stderr, "The extraction went wrong due to", err1)
 		os.Exit(1)
 	}
+	if *optics_groups_csv != "" {
+		err = result.WriteOpticsGroupsCSV(*optics_groups_csv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the optics groups:", err)
+		}
+	}
+	if *optics_groups_star != "" {
+		err = result.WriteOpticsGroupsSTAR(*optics_groups_star, *cs_value, *amplitude_contrast)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the optics groups:", err)
+		}
+	}
+	if *stage_map != "" {
+		err = result.WriteStageMap(*stage_map, *shift_overlay)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the stage map:", err)
+		}
+	}
+	if *report != "" {
+		err = result.WriteReport(*report, out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the session report:", err)
+		}
+	}
 	if *print_to_stdout {
 		fmt.Printf("%s", string(out))
 	}
+	if incomplete {
+		os.Exit(1)
+	}
+}
+
+// fullMetadataPath is the explicit path, or the one derived from the output of the OSC-EM json:
+// foo.json or foo (written as foo.json by the converter) give foo_full.json
+func fullMetadataPath(full_out string, output_file_path string, write_full_metadata bool) string {
+	if full_out != "" || !write_full_metadata || output_file_path == "" {
+		return full_out
+	}
+	return strings.TrimSuffix(output_file_path, ".json") + "_full.json"
 }
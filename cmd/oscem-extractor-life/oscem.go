@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// convertCommand is the hidden subcommand converting in a child process: the converter reports to stdout
+// and exits on errors, neither may reach the server or the watch output
+const convertCommand = "convert-oscem"
+
+// runConvert converts the metadata json on stdin into the OSC-EM json at -o
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet(convertCommand, flag.ContinueOnError)
+	cs := flags.String("cs", "", "CS value")
+	gain := flags.String("gain_flip_rotate", "", "gain reference orientation")
+	path := flags.String("o", "", "output path")
+	if err := flags.Parse(args); err != nil || *path == "" {
+		return 2
+	}
+	metadata, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := conversion.Convert(metadata, "", *cs, *gain, *path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// convertQuietly converts the metadata into the OSC-EM json at path in a child process, without the report
+// of the converter
+func convertQuietly(ctx context.Context, result *metadataparser.Result, cs string, path string) ([]byte, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	command := exec.CommandContext(ctx, executable, convertCommand, "-cs", cs, "-gain_flip_rotate", result.GainFlipRotate, "-o", path)
+	command.Stdin = bytes.NewReader(result.Metadata)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("conversion failed: %s", message)
+		}
+		return nil, fmt.Errorf("conversion failed: %w", err)
+	}
+	return os.ReadFile(path)
+}
+
+// writeOSCEM converts the metadata next to the output and renames it into place, so a reader never sees a
+// partially written file. The output is named as the converter does: <working directory>.json by default,
+// .json is appended if missing.
+func writeOSCEM(result *metadataparser.Result, cs string, output_file_path string) error {
+	path := output_file_path
+	if path == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		path = filepath.Base(cwd) + ".json"
+	} else if !strings.Contains(path, ".json") {
+		path += ".json"
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.json")
+	if err != nil {
+		return err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	// the converter would report the temporary file, the final one is reported instead
+	if _, err := convertQuietly(context.Background(), result, cs, temp.Name()); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	fmt.Println("Extracted data was written to:", path)
+	return nil
+}
+
+// oscemBytes converts the metadata without keeping a file
+func oscemBytes(ctx context.Context, result *metadataparser.Result, cs string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "oscem-extractor-life-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	return convertQuietly(ctx, result, cs, filepath.Join(dir, "oscem.json"))
+}
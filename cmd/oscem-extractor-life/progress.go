@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+)
+
+const progressUsage = "Report the progress on stderr: json (one event per line), tty (a progress line) or none; default tty if stderr is a terminal, none otherwise"
+
+// progressPrinter returns the callback printing the progress events to out in the given mode
+func progressPrinter(mode string, out *os.File) (func(metadataparser.ProgressEvent), error) {
+	if mode == "" {
+		mode = "none"
+		if info, err := out.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			mode = "tty"
+		}
+	}
+	switch mode {
+	case "none":
+		return nil, nil
+	case "json":
+		encoder := json.NewEncoder(out)
+		return func(event metadataparser.ProgressEvent) {
+			_ = encoder.Encode(event)
+		}, nil
+	case "tty":
+		return ttyProgress(out), nil
+	}
+	return nil, fmt.Errorf("unknown progress mode %q, use json, tty or none", mode)
+}
+
+// ttyProgress rewrites a progress line at most every 100ms and once all files are read
+func ttyProgress(out io.Writer) func(metadataparser.ProgressEvent) {
+	var last time.Time
+	return func(event metadataparser.ProgressEvent) {
+		switch event.Event {
+		case metadataparser.ProgressDiscovered:
+			fmt.Fprintf(out, "Total number of files to process: %d\n", event.Total)
+			last = time.Time{}
+		case metadataparser.ProgressParsed, metadataparser.ProgressFailed:
+			done := event.Parsed + event.Failed
+			if event.Total == 0 || (done < event.Total && time.Since(last) < 100*time.Millisecond) {
+				return
+			}
+			last = time.Now()
+			fmt.Fprintf(out, "\rProgress: %.2f%%", float64(done)/float64(event.Total)*100)
+			if done >= event.Total {
+				fmt.Fprintln(out)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+	"github.com/osc-em/oscem-extractor-life/internal/server"
+)
+
+// listFlag collects a flag given several times, or once with comma separated values
+type listFlag []string
+
+func (list *listFlag) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*list = append(*list, item)
+		}
+	}
+	return nil
+}
+
+func serveUsage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, `Usage: oscem-extractor-life serve --root <directory> [options]
+
+Runs extractions as jobs behind a REST API:
+  POST /jobs               {"path": "/data/session"} submits a job, answers 202 with the job
+  GET  /jobs               lists the recent jobs, newest first
+  GET  /jobs/{id}          status and progress (filesRead of filesTotal) of a job
+  GET  /jobs/{id}/oscem    the OSC-EM json of a finished job
+  GET  /jobs/{id}/full     the full metadata json of a finished job
+Only directories below a --root are read. The config is resolved for every job as for a single
+extraction, including the instrument profile matching the dataset.
+
+Options:`)
+	flags.PrintDefaults()
+}
+
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var roots listFlag
+	flags.Var(&roots, "root", "Directory the server may read datasets below, repeat or separate by commas for several")
+	listen := flags.String("listen", "localhost:8080", "Address to listen on, e.g. :8080 to accept connections from other hosts")
+	workers := flags.Int("jobs", 2, "Number of extractions running at the same time")
+	keep := flags.Int("keep", 100, "Number of finished jobs kept with their results")
+	config_file := flags.String("config", "", "Provide the path of a config file to use instead of the one in the user config directory")
+	profile := flags.String("profile", "", "Use this instrument profile of the config for all jobs instead of selecting one from the data")
+	// resolved through the config, see resolveSettings
+	flags.String("cs", "", "Provide CS value here, if you dont want to use configs")
+	flags.String("gain_flip_rotate", "", "Provide how to rotate/flip the gain ref here, if you dont want to use configs or derive it from the data")
+	flags.String("epu", "", "Provide the path to the mirrored EPU folder containing all the xmls of the datacollections here, if you dont want to use configs")
+	metadataFolder := flags.String("folder_filter", "", "If the system deviates from standard EPU naming conventions, a regex for the folder name with the metadata files can be provided.")
+	cache := flags.Bool("cache", false, "Keep the parsed metadata files in the user cache directory, so the next job on a dataset only parses new and changed files")
+	cache_dir := flags.String("cache_dir", "", "Keep the cache in this directory instead, implies --cache")
+	logging := addLogFlags(flags)
+	file_workers := flags.Int("workers", 0, "Number of metadata files read in parallel per job, 0 for the number of CPUs; more help on network mounts")
+	file_timeout := flags.Duration("file_timeout", 0, "Give up a metadata file that takes longer to read, e.g. on a stalled network mount; 0 for no limit")
+	flags.Usage = func() { serveUsage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		serveUsage(flags)
+		return 2
+	}
+	var logged metadataparser.Options
+	if err := logging.apply(&logged); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	extract := func(ctx context.Context, directory string, progress *metadataparser.ProgressTracker) ([]byte, []byte, error) {
+		cs, opts, err := resolveSettings(flags, *config_file, *profile, directory, *metadataFolder)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Progress = progress
+		opts.Logger, opts.Strict, opts.MaxFailureRatio = logged.Logger, logged.Strict, logged.MaxFailureRatio
+		opts.FileTimeout = *file_timeout
+		opts.Workers = *file_workers
+		opts.Cache = *cache
+		opts.CacheDir = *cache_dir
+		result, err := metadataparser.ExtractContext(ctx, directory, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		oscem, err := oscemBytes(ctx, result, cs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return oscem, result.Metadata, nil
+	}
+	jobs, err := server.New(roots, extract, *workers, *keep)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	httpServer := &http.Server{Addr: *listen, Handler: jobs.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		jobs.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(ctx)
+	}()
+	fmt.Fprintf(os.Stderr, "Serving extractions of datasets below %s on %s\n", strings.Join(roots, ", "), *listen)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
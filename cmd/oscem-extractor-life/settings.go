@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/osc-em/oscem-extractor-life/internal/configuration"
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+)
+
+// resolveSettings resolves the config of a run on directory, every value on its own: flags > env > instrument
+// profile > config file > defaults. It returns the CS and the options taken from the config.
+func resolveSettings(flags *flag.FlagSet, config_file string, profile string, directory string, metadataFolder string) (string, metadataparser.Options, error) {
+	config, err := configuration.Load(config_file, setFlags(flags))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Your config was unretrievable, make sure it is set and accessible or use the param flags:", err)
+	}
+	err = selectProfile(config, profile, directory, metadataFolder)
+	if err != nil {
+		if profile != "" {
+			return "", metadataparser.Options{}, err
+		}
+		fmt.Fprintln(os.Stderr, err)
+	}
+	// free-form values of older configs are deprecated but still passed on, the extraction warns about them
+	gain_flip_rotate := config.Get("Gainref_FlipRotate")
+	// the extraction reads no config, everything it needs from it is passed here
+	opts := metadataparser.Options{
+		EPUFolder:           config.Get("MPCPATH"),
+		MetadataFolderRegex: metadataFolder,
+		PathMappings:        config.PathMappings,
+		WindowsPaths:        config.WindowsPaths,
+	}
+	// a value given for this run or instrument beats the RotationAndFlip of the data, the general config does not;
+	// the general config still beats the assumed orientation of EER gain references
+	if gain, _ := config.Lookup("Gainref_FlipRotate"); gain.Source == configuration.SourceFile || gain.Source == configuration.SourceDefault {
+		opts.GainFlipRotateDefault = gain_flip_rotate
+	} else {
+		opts.GainFlipRotate = gain_flip_rotate
+	}
+	return config.Get("CS"), opts, nil
+}
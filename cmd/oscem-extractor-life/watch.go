@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+)
+
+func watchUsage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, `Usage: oscem-extractor-life watch [options] <directory>
+
+Extracts the metadata of a session while it is collected: the dataset and its EPU mirror are polled for
+new or changed xml and mdoc files, only those are parsed, and the OSC-EM json is rewritten every --interval
+and once more when the session ends (no new files for --idle) or on Ctrl-C.
+
+Options (before the directory):`)
+	flags.PrintDefaults()
+}
+
+func runWatch(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	config_file := flags.String("config", "", "Provide the path of a config file to use instead of the one in the user config directory")
+	profile := flags.String("profile", "", "Use this instrument profile of the config instead of selecting one from the data")
+	// resolved through the config, see resolveSettings
+	flags.String("cs", "", "Provide CS value here, if you dont want to use configs")
+	flags.String("gain_flip_rotate", "", "Provide how to rotate/flip the gain ref here, if you dont want to use configs or derive it from the data")
+	flags.String("epu", "", "Provide the path to the mirrored EPU folder containing all the xmls of the datacollections here, if you dont want to use configs")
+	metadataFolder := flags.String("folder_filter", "", "If the system deviates from standard EPU naming conventions, a regex for the folder name with the metadata files can be provided.")
+	explain_paths := flags.Bool("explain-paths", false, "Print which EPU mirror folders were tried for the dataset and which metadata folders were searched")
+	output_file_path := flags.String("o", "", "Provide target output path and name for your metadata file, leave empty to write to current working directory")
+	write_full_metadata := flags.Bool("f", false, "Toggle whether the full metadata is also written out in addition to the OSCEM schema conform one")
+	full_out := flags.String("full_out", "", "Provide a path for the full metadata, implies -f")
+	force := flags.Bool("force", false, "Overwrite an existing full metadata file when the watch starts")
+	xml_types := flags.Bool("xml_types", false, "Keep the i:type of the EPU CustomData entries as <key>.@type in the full metadata")
+	cache := flags.Bool("cache", false, "Keep the parsed metadata files in the user cache directory, so the next run only parses new and changed files")
+	cache_dir := flags.String("cache_dir", "", "Keep the cache in this directory instead, implies --cache")
+	progress := flags.String("progress", "", progressUsage)
+	logging := addLogFlags(flags)
+	workers := flags.Int("workers", 0, "Number of metadata files read in parallel, 0 for the number of CPUs; more help on network mounts")
+	file_timeout := flags.Duration("file_timeout", 0, "Give up a metadata file that takes longer to read, e.g. on a stalled network mount; it is retried once it changes. 0 for no limit")
+	poll := flags.Duration("poll", 10*time.Second, "How often the folders are scanned for new and changed files")
+	interval := flags.Duration("interval", time.Minute, "How often the metadata is rewritten while files arrive, 0 to write it only at the end of the session")
+	idle := flags.Duration("idle", 30*time.Minute, "End the session once no metadata file arrived or changed for this long, 0 to watch until interrupted")
+	flags.Usage = func() { watchUsage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		watchUsage(flags)
+		return 2
+	}
+	directory := flags.Arg(0)
+
+	cs, opts, err := resolveSettings(flags, *config_file, *profile, directory, *metadataFolder)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	opts.WriteFullMetadata = *write_full_metadata
+	opts.FullMetadataOut = fullMetadataPath(*full_out, *output_file_path, *write_full_metadata)
+	opts.Force = *force
+	opts.XMLTypes = *xml_types
+	opts.Cache = *cache
+	opts.CacheDir = *cache_dir
+	opts.ExplainPaths = *explain_paths
+	opts.FileTimeout = *file_timeout
+	opts.Workers = *workers
+	opts.OnProgress, err = progressPrinter(*progress, os.Stderr)
+	if err == nil {
+		err = logging.apply(&opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "Stopping, writing the metadata a last time")
+		stop()
+	}()
+
+	_, err = metadataparser.Watch(ctx, directory, opts, metadataparser.WatchOptions{
+		PollInterval:  *poll,
+		WriteInterval: *interval,
+		IdleTimeout:   *idle,
+		OnUpdate: func(result *metadataparser.Result, final bool) error {
+			return writeOSCEM(result, cs, *output_file_path)
+		},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "The extraction went wrong due to", err)
+		return 1
+	}
+	return 0
+}
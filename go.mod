@@ -5,9 +5,9 @@ go 1.23.0
 //replace github.com/osc-em/oscem-converter-extracted => ../oscem-converter-extracted
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/osc-em/oscem-converter-extracted v1.0.4
 	github.com/stretchr/testify v1.11.1
-	golang.org/x/exp v0.0.0-20250506013437-ce4c2cf36ca6
 )
 
 require (
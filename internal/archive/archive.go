@@ -0,0 +1,192 @@
+// Package archive packs the metadata files of a dataset into a zip or tar.gz archive. The archive
+// only depends on the files: entries are sorted by name and carry fixed permissions and the
+// modification time of the file, so extracting the same dataset twice gives identical archives.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+// File is a file on disk and its name in the archive, relative and with forward slashes
+type File struct {
+	Name string
+	Path string
+}
+
+// Format returns format, or the one matching the extension of path if format is empty
+func Format(path string, format string) (string, error) {
+	if format == "" {
+		lower := strings.ToLower(path)
+		if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+			return FormatTarGz, nil
+		}
+		return FormatZip, nil
+	}
+	switch strings.ToLower(format) {
+	case FormatZip:
+		return FormatZip, nil
+	case FormatTarGz, "tgz":
+		return FormatTarGz, nil
+	default:
+		return "", fmt.Errorf("unknown archive format %q, use %s or %s", format, FormatZip, FormatTarGz)
+	}
+}
+
+// Check tells whether format and compression level are known, before any file is read.
+// Level 0 is the default compression, 1 the fastest and 9 the best.
+func Check(format string, level int) error {
+	if _, err := Format("", format); err != nil {
+		return err
+	}
+	if level < 0 || level > 9 {
+		return fmt.Errorf("compression level must be 0 (default) or 1-9, got %d", level)
+	}
+	return nil
+}
+
+// Write creates the archive at path via a temporary file, so a failed run leaves no partial archive behind.
+// Files with the same name as an earlier one are skipped, the caller orders them by preference.
+func Write(path string, files []File, format string, level int) error {
+	format, err := Format(path, format)
+	if err != nil {
+		return err
+	}
+	if err := Check(format, level); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	err = write(temp, sorted(files), format, level)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes the file private, the archive is meant to be shared like the other outputs
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+func sorted(files []File) []File {
+	seen := make(map[string]bool)
+	var unique []File
+	for _, file := range files {
+		name := strings.TrimPrefix(filepath.ToSlash(file.Name), "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		unique = append(unique, File{Name: name, Path: file.Path})
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i].Name < unique[j].Name })
+	return unique
+}
+
+func write(w io.Writer, files []File, format string, level int) error {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	switch format {
+	case FormatTarGz:
+		compressed, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return err
+		}
+		writer := tar.NewWriter(compressed)
+		for _, file := range files {
+			if err := addToTar(writer, file); err != nil {
+				return err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		return compressed.Close()
+	default:
+		writer := zip.NewWriter(w)
+		writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+		for _, file := range files {
+			if err := addToZip(writer, file); err != nil {
+				return err
+			}
+		}
+		return writer.Close()
+	}
+}
+
+func open(file File) (*os.File, os.FileInfo, error) {
+	opened, err := os.Open(file.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := opened.Stat()
+	if err != nil {
+		opened.Close()
+		return nil, nil, err
+	}
+	return opened, info, nil
+}
+
+// modTime drops what depends on the machine: sub-second precision and the time zone
+func modTime(info os.FileInfo) time.Time {
+	return info.ModTime().UTC().Truncate(time.Second)
+}
+
+func addToZip(writer *zip.Writer, file File) error {
+	opened, info, err := open(file)
+	if err != nil {
+		return err
+	}
+	defer opened.Close()
+	header := &zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: modTime(info)}
+	header.SetMode(0644)
+	entry, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, opened)
+	return err
+}
+
+func addToTar(writer *tar.Writer, file File) error {
+	opened, info, err := open(file)
+	if err != nil {
+		return err
+	}
+	defer opened.Close()
+	err = writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.Name,
+		Size:     info.Size(),
+		Mode:     0644,
+		ModTime:  modTime(info),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, opened)
+	return err
+}
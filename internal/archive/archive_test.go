@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		path   string
+		format string
+		want   string
+		err    bool
+	}{
+		{path: "out.zip", want: FormatZip},
+		{path: "out.tar.gz", want: FormatTarGz},
+		{path: "OUT.TGZ", want: FormatTarGz},
+		{path: "out", want: FormatZip},
+		{path: "out.zip", format: "tar.gz", want: FormatTarGz},
+		{path: "out", format: "rar", err: true},
+	}
+	for _, tt := range tests {
+		got, err := Format(tt.path, tt.format)
+		if tt.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.path)
+	}
+	assert.Error(t, Check(FormatZip, 10))
+	assert.NoError(t, Check("", 9))
+}
+
+func TestWrite(t *testing.T) {
+	root := t.TempDir()
+	stamp := time.Date(2024, 9, 1, 6, 1, 8, 500, time.UTC)
+	var files []File
+	// same basename in two grid squares, given out of order
+	for _, name := range []string{"GridSquare_2/Data/a.xml", "EpuSession.dm", "GridSquare_1/Data/a.xml"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(name), 0644))
+		assert.NoError(t, os.Chtimes(path, stamp, stamp))
+		files = append(files, File{Name: name, Path: path})
+	}
+	files = append(files, File{Name: "EpuSession.dm", Path: files[0].Path})
+	want := []string{"EpuSession.dm", "GridSquare_1/Data/a.xml", "GridSquare_2/Data/a.xml"}
+
+	for _, format := range []string{FormatZip, FormatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			out := t.TempDir()
+			first, second := filepath.Join(out, "first"), filepath.Join(out, "second")
+			assert.NoError(t, Write(first, files, format, 9))
+			assert.NoError(t, Write(second, files, format, 9))
+			a, _ := os.ReadFile(first)
+			b, _ := os.ReadFile(second)
+			assert.True(t, bytes.Equal(a, b), "archives differ")
+			info, err := os.Stat(first)
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+			names, contents := readArchive(t, a, format)
+			assert.Equal(t, want, names)
+			assert.Equal(t, "GridSquare_1/Data/a.xml", contents[1])
+			// a duplicate name keeps the first file
+			assert.Equal(t, "EpuSession.dm", contents[0])
+		})
+	}
+
+	// a failed archive leaves nothing behind
+	out := t.TempDir()
+	assert.Error(t, Write(filepath.Join(out, "x.zip"), []File{{Name: "gone", Path: filepath.Join(root, "gone")}}, "", 0))
+	leftovers, _ := filepath.Glob(filepath.Join(out, "*"))
+	assert.Empty(t, leftovers)
+}
+
+func readArchive(t *testing.T, content []byte, format string) ([]string, []string) {
+	var names, contents []string
+	stamp := time.Date(2024, 9, 1, 6, 1, 8, 0, time.UTC)
+	if format == FormatZip {
+		reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		assert.NoError(t, err)
+		for _, file := range reader.File {
+			names = append(names, file.Name)
+			assert.True(t, stamp.Equal(file.Modified), file.Modified.String())
+			opened, err := file.Open()
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(opened)
+			contents = append(contents, string(data))
+		}
+		return names, contents
+	}
+	compressed, err := gzip.NewReader(bytes.NewReader(content))
+	assert.NoError(t, err)
+	reader := tar.NewReader(compressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, header.Name)
+		assert.True(t, stamp.Equal(header.ModTime), header.ModTime.String())
+		data, _ := io.ReadAll(reader)
+		contents = append(contents, string(data))
+	}
+	return names, contents
+}
@@ -0,0 +1,212 @@
+// Package autodoc reads the IMOD autodoc format of the SerialEM and Tomo5 mdoc files.
+//
+// An autodoc starts with a global header of key value pairs, followed by sections. Every section
+// starts with a [Type = Value] line and holds the key value pairs up to the next one:
+//
+//	PixelSpacing = 2.66
+//	ImageSize = 3708 3838
+//	[T = SerialEM: Digitized by Gatan K2 Summit ...]
+//	[T = Tilt axis angle = 84.3, binning = 1  spot = 6  camera = 0]
+//	[ZValue = 0]
+//	TiltAngle = -66.9998
+//	StagePosition = 4.97259 -299.41
+//
+// The [T = ...] titles are sections without pairs, the movies are in [ZValue] (tilt series) or
+// [FrameSet] (single movies) sections, the pieces of a montage in [MontSection] sections. Lines
+// starting with # are comments. Lines that cannot be read are collected in Errors and skipped.
+package autodoc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// section types of the mdoc files
+const (
+	TypeTitle       = "T"
+	TypeZValue      = "ZValue"
+	TypeFrameSet    = "FrameSet"
+	TypeMontSection = "MontSection"
+)
+
+// Value is the text after the =, as written but without the leading spaces
+type Value string
+
+// String is the value without the surrounding spaces
+func (v Value) String() string {
+	return strings.TrimSpace(string(v))
+}
+
+// Fields are the space separated parts of the value
+func (v Value) Fields() []string {
+	return strings.Fields(string(v))
+}
+
+func (v Value) Float() (float64, bool) {
+	number, err := strconv.ParseFloat(v.String(), 64)
+	return number, err == nil
+}
+
+func (v Value) Int() (int, bool) {
+	number, err := strconv.Atoi(v.String())
+	return number, err == nil
+}
+
+// Floats reads a value of space separated numbers, e.g. StagePosition = 4.97259 -299.41
+func (v Value) Floats() ([]float64, bool) {
+	fields := v.Fields()
+	if len(fields) == 0 {
+		return nil, false
+	}
+	numbers := make([]float64, len(fields))
+	for i, field := range fields {
+		number, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, false
+		}
+		numbers[i] = number
+	}
+	return numbers, true
+}
+
+// Entry is a key value pair, Line counts from 1
+type Entry struct {
+	Key   string
+	Value Value
+	Line  int
+}
+
+type Section struct {
+	Type    string
+	Value   Value
+	Line    int
+	Entries []Entry
+}
+
+// Movie tells if the section is a movie or tilt
+func (s Section) Movie() bool {
+	return s.Type == TypeZValue || s.Type == TypeFrameSet
+}
+
+// Lookup returns the first value of key in the section
+func (s Section) Lookup(key string) (Value, bool) {
+	for _, entry := range s.Entries {
+		if entry.Key == key {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// SerialEM writes "Tilt axis angle = 84.3, binning = 1", Tomo5 "TiltAxisAngle = 84.9  Binning = 1"
+var tiltAxisAngle = regexp.MustCompile(`(?i)tilt\s*axis\s*angle\s*=\s*([-+]?[0-9.]+(?:[eE][-+]?[0-9]+)?)`)
+
+// TiltAxisAngle is the tilt axis angle given in a title
+func (s Section) TiltAxisAngle() (string, bool) {
+	if s.Type != TypeTitle {
+		return "", false
+	}
+	match := tiltAxisAngle.FindStringSubmatch(string(s.Value))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+type Document struct {
+	// the pairs before the first section
+	Header   []Entry
+	Sections []Section
+	// the lines that were skipped
+	Errors []Error
+}
+
+// Titles are the values of the [T = ...] sections
+func (d *Document) Titles() []string {
+	var titles []string
+	for _, section := range d.Sections {
+		if section.Type == TypeTitle {
+			titles = append(titles, section.Value.String())
+		}
+	}
+	return titles
+}
+
+// Error is a line of an autodoc that could not be read
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errNoEquals       = errors.New("expected key = value")
+	errNoKey          = errors.New("missing key before =")
+	errUnclosedHeader = errors.New("section header without ]")
+)
+
+// Parse reads an autodoc. The lines that cannot be read are skipped and listed in the Errors of the
+// document; the returned error is an *Error for a failed read, the document then holds the lines before.
+func Parse(r io.Reader) (*Document, error) {
+	doc := &Document{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		// the scanner drops the \r of a \r\n, mdocs converted twice end their lines with \r\r\n
+		raw := strings.TrimRight(scanner.Text(), "\r")
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			end := strings.LastIndex(text, "]")
+			if end < 0 {
+				doc.Errors = append(doc.Errors, Error{Line: line, Err: errUnclosedHeader})
+				continue
+			}
+			kind, value, _ := strings.Cut(text[1:end], "=")
+			kind = strings.TrimSpace(kind)
+			if kind == "" {
+				doc.Errors = append(doc.Errors, Error{Line: line, Err: errNoKey})
+				continue
+			}
+			doc.Sections = append(doc.Sections, Section{Type: kind, Value: Value(strings.TrimLeft(value, " \t")), Line: line})
+			continue
+		}
+		// the value keeps its trailing spaces
+		key, value, found := strings.Cut(raw, "=")
+		if !found {
+			doc.Errors = append(doc.Errors, Error{Line: line, Err: errNoEquals})
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			doc.Errors = append(doc.Errors, Error{Line: line, Err: errNoKey})
+			continue
+		}
+		entry := Entry{Key: key, Value: Value(strings.TrimLeft(value, " \t")), Line: line}
+		if len(doc.Sections) == 0 {
+			doc.Header = append(doc.Header, entry)
+		} else {
+			last := &doc.Sections[len(doc.Sections)-1]
+			last.Entries = append(last.Entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return doc, &Error{Line: line + 1, Err: err}
+	}
+	return doc, nil
+}
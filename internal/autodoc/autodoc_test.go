@@ -0,0 +1,121 @@
+package autodoc
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	input := "PixelSpacing = 2.66\r\nImageSize = 3708 3838\r\r\n\r\n" +
+		"[T = SerialEM: Digitized on Titan Krios    ]\n" +
+		"[T =     Tilt axis angle = 84.3, binning = 1  spot = 6  camera = 0]\n" +
+		"# a comment\n" +
+		"[ZValue = 0]\n" +
+		"TiltAngle = -66.9998\n" +
+		"no equals sign\n" +
+		"StagePosition = 4.97259 -299.41\n" +
+		"= 5\n" +
+		"[MontSection = 0\n" +
+		"[MontSection = 1]\n" +
+		"PieceCoordinates = 0 0 0\n" +
+		"DateTime = 03-May-23  13:59:32  \n"
+	doc, err := Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Entry{{Key: "PixelSpacing", Value: "2.66", Line: 1}, {Key: "ImageSize", Value: "3708 3838", Line: 2}}, doc.Header)
+	assert.Len(t, doc.Sections, 4)
+	assert.Equal(t, []string{"SerialEM: Digitized on Titan Krios", "Tilt axis angle = 84.3, binning = 1  spot = 6  camera = 0"}, doc.Titles())
+	assert.Equal(t, Value("SerialEM: Digitized on Titan Krios    "), doc.Sections[0].Value)
+
+	zvalue := doc.Sections[2]
+	assert.Equal(t, TypeZValue, zvalue.Type)
+	assert.Equal(t, 7, zvalue.Line)
+	assert.True(t, zvalue.Movie())
+	assert.Equal(t, []Entry{{Key: "TiltAngle", Value: "-66.9998", Line: 8}, {Key: "StagePosition", Value: "4.97259 -299.41", Line: 10}}, zvalue.Entries)
+
+	montage := doc.Sections[3]
+	assert.Equal(t, TypeMontSection, montage.Type)
+	assert.False(t, montage.Movie())
+	dateTime, ok := montage.Lookup("DateTime")
+	assert.True(t, ok)
+	assert.Equal(t, Value("03-May-23  13:59:32  "), dateTime)
+	assert.Equal(t, "03-May-23  13:59:32", dateTime.String())
+	_, ok = montage.Lookup("TiltAngle")
+	assert.False(t, ok)
+
+	assert.Equal(t, []Error{
+		{Line: 9, Err: errNoEquals},
+		{Line: 11, Err: errNoKey},
+		{Line: 12, Err: errUnclosedHeader},
+	}, doc.Errors)
+}
+
+func TestTiltAxisAngle(t *testing.T) {
+	tests := []struct {
+		section Section
+		want    string
+		ok      bool
+	}{
+		{section: Section{Type: TypeTitle, Value: "Tilt axis angle = 84.3, binning = 1  spot = 6  camera = 0]"}, want: "84.3", ok: true},
+		{section: Section{Type: TypeTitle, Value: "TOMOGRAPHY  TiltAxisAngle = -95.2  Binning = 1"}, want: "-95.2", ok: true},
+		{section: Section{Type: TypeTitle, Value: "TiltAxisAngle"}},
+		{section: Section{Type: TypeTitle, Value: "SerialEM: Digitized by Gatan K2 Summit"}},
+		{section: Section{Type: TypeZValue, Value: "Tilt axis angle = 84.3"}},
+	}
+	for _, tt := range tests {
+		got, ok := tt.section.TiltAxisAngle()
+		assert.Equal(t, tt.ok, ok, tt.section.Value)
+		assert.Equal(t, tt.want, got, tt.section.Value)
+	}
+}
+
+func TestValue(t *testing.T) {
+	number, ok := Value(" 5.77443 ").Float()
+	assert.True(t, ok)
+	assert.Equal(t, 5.77443, number)
+	_, ok = Value("1.70971 0.195647").Float()
+	assert.False(t, ok)
+
+	integer, ok := Value("28").Int()
+	assert.True(t, ok)
+	assert.Equal(t, 28, integer)
+
+	numbers, ok := Value("1.70971  0.195647").Floats()
+	assert.True(t, ok)
+	assert.Equal(t, []float64{1.70971, 0.195647}, numbers)
+	_, ok = Value("0.1186 frames").Floats()
+	assert.False(t, ok)
+	_, ok = Value("").Floats()
+	assert.False(t, ok)
+}
+
+func TestParseFixtures(t *testing.T) {
+	content, err := os.ReadFile("../../tests/mdocs/TS_41.mrc.mdoc")
+	assert.NoError(t, err)
+	doc, err := Parse(strings.NewReader(string(content)))
+	assert.NoError(t, err)
+	assert.Empty(t, doc.Errors)
+	assert.Len(t, doc.Header, 5)
+	zvalues := 0
+	for _, section := range doc.Sections {
+		if section.Type == TypeZValue {
+			zvalues++
+		}
+		if angle, ok := section.TiltAxisAngle(); ok {
+			assert.Equal(t, "84.3", angle)
+		}
+	}
+	assert.Greater(t, zvalues, 0)
+}
+
+func TestParseLongLine(t *testing.T) {
+	doc, err := Parse(strings.NewReader("A = 1\nB = " + strings.Repeat("x", 70000) + "\n"))
+	var lineErr *Error
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, 2, lineErr.Line)
+	assert.Len(t, doc.Header, 1)
+}
@@ -0,0 +1,365 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/keypattern"
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+)
+
+// Sources of a configuration value, later ones take precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceProfile = "profile"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// ProfileEnv forces an instrument profile, like the --profile flag
+const ProfileEnv = "OSCEM_PROFILE"
+
+// name of the section holding the instrument profiles in the config file
+const profilesKey = "Profiles"
+
+// name of the list of EPU mirror mapping rules in the config file, see pathmap.Rule
+const pathMappingsKey = "PathMappings"
+
+// name of the list of drive/UNC to POSIX rules in the config file, see pathmap.WindowsRule
+const windowsPathsKey = "WindowsPaths"
+
+// Key describes a configuration value, Name is how it is stored in the config file.
+type Key struct {
+	Name        string
+	Flag        string
+	Default     string
+	Description string
+}
+
+var Keys = []Key{
+	{Name: "CS", Flag: "cs", Description: "spherical aberration of the instrument in mm"},
+	{Name: "Gainref_FlipRotate", Flag: "gain_flip_rotate", Description: "orientation of the gain reference relative to the data"},
+	{Name: "MPCPATH", Flag: "epu", Description: "path where EPU mirrors the datasets and stores its xmls"},
+}
+
+// Env is the environment variable that overrides the key, e.g. OSCEM_MPCPATH.
+func (key Key) Env() string {
+	return "OSCEM_" + strings.ToUpper(key.Name)
+}
+
+type Value struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Profile holds the values of one instrument. It is selected automatically when all Match
+// entries (metadata key, * as wildcard -> value) are found in the data.
+type Profile struct {
+	Values map[string]string
+	Match  map[string]string
+}
+
+// Config holds the merged configuration, every key resolved from defaults < file < profile < env < flags.
+type Config struct {
+	Path     string
+	Profile  string
+	Profiles map[string]Profile
+	// rules to find the EPU mirror of a dataset, tried before MPCPATH
+	PathMappings []pathmap.Rule
+	// rules to find the movies referenced with paths of the acquisition computer
+	WindowsPaths []pathmap.WindowsRule
+	file         map[string]string
+	flags        map[string]string
+	values       map[string]Value
+}
+
+// DefaultPath is the location of the config file in the user config directory.
+func DefaultPath() (string, error) {
+	path, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, "oscem-extractor-life", "oscem-extractor-life.conf"), nil
+}
+
+// ReadFile reads a config file. Keys are matched case-insensitively, older versions read
+// "cs"/"gainref_flip_rotate" while the wizard wrote "CS"/"Gainref_FlipRotate".
+func ReadFile(path string) (map[string]string, map[string]Profile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make(map[string]string)
+	profiles := make(map[string]Profile)
+	for name, rawValue := range raw {
+		if strings.EqualFold(name, profilesKey) {
+			var rawProfiles map[string]map[string]json.RawMessage
+			err = json.Unmarshal(rawValue, &rawProfiles)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", profilesKey, err)
+			}
+			for profileName, rawProfile := range rawProfiles {
+				profile, err := parseProfile(rawProfile)
+				if err != nil {
+					return nil, nil, fmt.Errorf("profile %s: %w", profileName, err)
+				}
+				profiles[profileName] = profile
+			}
+			continue
+		}
+		if strings.EqualFold(name, pathMappingsKey) || strings.EqualFold(name, windowsPathsKey) {
+			continue
+		}
+		var value string
+		err = json.Unmarshal(rawValue, &value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		values[canonicalName(name)] = value
+	}
+	return values, profiles, nil
+}
+
+// ReadPathMappings reads the EPU mirror mapping rules of a config file
+func ReadPathMappings(path string) ([]pathmap.Rule, error) {
+	var rules []pathmap.Rule
+	err := readSection(path, pathMappingsKey, &rules)
+	return rules, err
+}
+
+// validateRules returns the error of the first rule that cannot be applied
+func validateRules(mappings []pathmap.Rule, windows []pathmap.WindowsRule) error {
+	for _, rule := range mappings {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", pathMappingsKey, err)
+		}
+	}
+	for _, rule := range windows {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", windowsPathsKey, err)
+		}
+	}
+	return nil
+}
+
+// ReadWindowsPaths reads the rules translating paths of the acquisition computer
+func ReadWindowsPaths(path string) ([]pathmap.WindowsRule, error) {
+	var rules []pathmap.WindowsRule
+	err := readSection(path, windowsPathsKey, &rules)
+	return rules, err
+}
+
+// readSection decodes the structured section name of a config file into target, if it is present
+func readSection(path string, name string, target interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(content, &raw)
+	if err != nil {
+		return err
+	}
+	for key, rawValue := range raw {
+		if strings.EqualFold(key, name) {
+			decoder := json.NewDecoder(bytes.NewReader(rawValue))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(target)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseProfile(raw map[string]json.RawMessage) (Profile, error) {
+	profile := Profile{Values: make(map[string]string), Match: make(map[string]string)}
+	for name, rawValue := range raw {
+		var err error
+		if strings.EqualFold(name, "Match") {
+			err = json.Unmarshal(rawValue, &profile.Match)
+		} else {
+			var value string
+			err = json.Unmarshal(rawValue, &value)
+			profile.Values[canonicalName(name)] = value
+		}
+		if err != nil {
+			return profile, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return profile, nil
+}
+
+// canonicalName maps the spellings of a key (any case, or its flag name) to the stored one
+func canonicalName(name string) string {
+	for _, key := range Keys {
+		if strings.EqualFold(key.Name, name) || name == key.Flag {
+			return key.Name
+		}
+	}
+	return name
+}
+
+// Load merges the configuration layers. path is the config file ("" for DefaultPath), a missing file
+// is not an error. flags holds the values of explicitly set command line flags by flag name.
+// A profile forced via OSCEM_PROFILE is applied, otherwise see UseProfile and MatchProfile.
+func Load(path string, flags map[string]string) (*Config, error) {
+	if path == "" {
+		defaultPath, err := DefaultPath()
+		if err == nil {
+			path = defaultPath
+		}
+	}
+	config := &Config{Path: path, Profiles: make(map[string]Profile), flags: flags}
+	if path != "" {
+		values, profiles, err := ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			config.resolve()
+			return config, err
+		}
+		config.file = values
+		if profiles != nil {
+			config.Profiles = profiles
+		}
+		if err == nil {
+			config.PathMappings, err = ReadPathMappings(path)
+			if err == nil {
+				config.WindowsPaths, err = ReadWindowsPaths(path)
+			}
+			if err == nil {
+				err = validateRules(config.PathMappings, config.WindowsPaths)
+			}
+			if err != nil {
+				// rules that cannot be applied are not used at all
+				config.PathMappings, config.WindowsPaths = nil, nil
+				config.resolve()
+				return config, err
+			}
+		}
+	}
+	if name, set := os.LookupEnv(ProfileEnv); set && name != "" {
+		return config, config.UseProfile(name)
+	}
+	config.resolve()
+	return config, nil
+}
+
+func (config *Config) resolve() {
+	config.values = make(map[string]Value)
+	for _, key := range Keys {
+		config.values[key.Name] = Value{Key: key.Name, Value: key.Default, Source: SourceDefault}
+	}
+	for name, value := range config.file {
+		config.values[name] = Value{Key: name, Value: value, Source: SourceFile}
+	}
+	if profile, exists := config.Profiles[config.Profile]; exists {
+		for name, value := range profile.Values {
+			config.values[name] = Value{Key: name, Value: value, Source: SourceProfile + ":" + config.Profile}
+		}
+	}
+	for _, key := range Keys {
+		if value, set := os.LookupEnv(key.Env()); set {
+			config.values[key.Name] = Value{Key: key.Name, Value: value, Source: SourceEnv}
+		}
+		if value, set := config.flags[key.Flag]; set {
+			config.values[key.Name] = Value{Key: key.Name, Value: value, Source: SourceFlag}
+		}
+	}
+}
+
+// UseProfile selects the instrument profile whose values are layered between file and env.
+func (config *Config) UseProfile(name string) error {
+	if _, exists := config.Profiles[name]; !exists {
+		config.resolve()
+		return fmt.Errorf("no instrument profile %q in %s", name, config.Path)
+	}
+	config.Profile = name
+	config.resolve()
+	return nil
+}
+
+// MatchProfile returns the profile whose match entries all agree with the metadata sample,
+// the most specific one if several match, "" if none does.
+func (config *Config) MatchProfile(sample map[string]string) (string, error) {
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	best, bestCount, ambiguous := "", 0, false
+	for _, name := range names {
+		match := config.Profiles[name].Match
+		if len(match) == 0 || !matchesSample(match, sample) {
+			continue
+		}
+		if len(match) > bestCount {
+			best, bestCount, ambiguous = name, len(match), false
+		} else if len(match) == bestCount {
+			ambiguous = true
+		}
+	}
+	if ambiguous {
+		return best, fmt.Errorf("several instrument profiles match the data, using %s - use --profile to choose", best)
+	}
+	return best, nil
+}
+
+func matchesSample(match map[string]string, sample map[string]string) bool {
+	for key, want := range match {
+		pattern := keypattern.Compile(key)
+		found := false
+		for name, value := range sample {
+			if pattern.MatchString(name) && strings.TrimSpace(value) == strings.TrimSpace(want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (config *Config) Get(name string) string {
+	return config.values[canonicalName(name)].Value
+}
+
+func (config *Config) Lookup(name string) (Value, bool) {
+	value, exists := config.values[canonicalName(name)]
+	return value, exists
+}
+
+// Effective lists the known keys first, followed by any other keys of the file.
+func (config *Config) Effective() []Value {
+	var values []Value
+	known := make(map[string]bool)
+	for _, key := range Keys {
+		values = append(values, config.values[key.Name])
+		known[key.Name] = true
+	}
+	var others []string
+	for name := range config.values {
+		if !known[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		values = append(values, config.values[name])
+	}
+	return values
+}
@@ -0,0 +1,129 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oscem-extractor-life.conf")
+	// lower case keys as read by older versions are still accepted
+	err := os.WriteFile(path, []byte(`{"cs": "2.7", "Gainref_FlipRotate": "flipx", "MPCPATH": "/mnt/epu/"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OSCEM_MPCPATH", "/env/epu/")
+	t.Setenv("OSCEM_GAINREF_FLIPROTATE", "flipy")
+
+	config, err := Load(path, map[string]string{"gain_flip_rotate": "none"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		key        string
+		wantValue  string
+		wantSource string
+	}{
+		{key: "CS", wantValue: "2.7", wantSource: SourceFile},
+		{key: "MPCPATH", wantValue: "/env/epu/", wantSource: SourceEnv},
+		{key: "Gainref_FlipRotate", wantValue: "none", wantSource: SourceFlag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value, exists := config.Lookup(tt.key)
+			assert.True(t, exists)
+			assert.Equal(t, tt.wantValue, value.Value)
+			assert.Equal(t, tt.wantSource, value.Source)
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	config, err := Load(filepath.Join(t.TempDir(), "missing.conf"), nil)
+	assert.NoError(t, err)
+	for _, value := range config.Effective() {
+		assert.Equal(t, SourceDefault, value.Source)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oscem-extractor-life.conf")
+	err := os.WriteFile(path, []byte(`{
+		"CS": "2.7", "Gainref_FlipRotate": "flipx", "MPCPATH": "/mnt/epu/",
+		"Profiles": {
+			"krios": {"cs": "2.7", "MPCPATH": "/mnt/krios/", "Match": {"MicroscopeImage.microscopeData.instrument.InstrumentID": "3926"}},
+			"krios-falcon": {"Gainref_FlipRotate": "none", "Match": {
+				"MicroscopeImage.microscopeData.instrument.InstrumentID": "3926",
+				"MicroscopeImage.CustomData.Detectors[*].CameraSerialNumber": "F4-123"}},
+			"glacios": {"CS": "2.7", "MPCPATH": "/mnt/glacios/", "Match": {"Camera0": "K3"}}
+		}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sample    map[string]string
+		wantMatch string
+		wantErr   bool
+	}{
+		{name: "instrument", sample: map[string]string{"MicroscopeImage.microscopeData.instrument.InstrumentID": "3926"}, wantMatch: "krios"},
+		{name: "most specific", sample: map[string]string{
+			"MicroscopeImage.microscopeData.instrument.InstrumentID":             "3926",
+			"MicroscopeImage.CustomData.Detectors[EF-Falcon].CameraSerialNumber": "F4-123"}, wantMatch: "krios-falcon"},
+		{name: "mdoc", sample: map[string]string{"Camera0": " K3 "}, wantMatch: "glacios"},
+		{name: "none", sample: map[string]string{"Camera0": "Falcon"}, wantMatch: ""},
+	}
+	config, err := Load(path, nil)
+	assert.NoError(t, err)
+	assert.Len(t, config.Profiles, 3)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := config.MatchProfile(tt.sample)
+			assert.Equal(t, tt.wantMatch, name)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+
+	// profile values sit between the file and env/flags
+	t.Setenv("OSCEM_MPCPATH", "/env/epu/")
+	config, err = Load(path, map[string]string{"cs": "0.01"})
+	assert.NoError(t, err)
+	assert.NoError(t, config.UseProfile("krios-falcon"))
+	value, _ := config.Lookup("Gainref_FlipRotate")
+	assert.Equal(t, Value{Key: "Gainref_FlipRotate", Value: "none", Source: "profile:krios-falcon"}, value)
+	assert.Equal(t, "/env/epu/", config.Get("MPCPATH"))
+	assert.Equal(t, "0.01", config.Get("CS"))
+	assert.Error(t, config.UseProfile("missing"))
+
+	t.Setenv(ProfileEnv, "glacios")
+	t.Setenv("OSCEM_MPCPATH", "")
+	os.Unsetenv("OSCEM_MPCPATH")
+	config, err = Load(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "glacios", config.Profile)
+	assert.Equal(t, "/mnt/glacios/", config.Get("MPCPATH"))
+}
+
+func TestProfileAmbiguous(t *testing.T) {
+	config := &Config{Profiles: map[string]Profile{
+		"a": {Match: map[string]string{"Camera0": "K3"}},
+		"b": {Match: map[string]string{"Camera0": "K3"}},
+	}}
+	name, err := config.MatchProfile(map[string]string{"Camera0": "K3"})
+	assert.Equal(t, "a", name)
+	assert.Error(t, err)
+}
+
+func TestLoadInvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oscem.conf")
+	content := `{"CS": "2.7", "PathMappings": [{"Prefix": "/data/offload/", "Replace": "/mnt/epu/"}, {"Prefix": "/data/"}]}`
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	config, err := Load(path, nil)
+	assert.ErrorContains(t, err, "PathMappings")
+	// none of the rules is used, the values still are
+	assert.Empty(t, config.PathMappings)
+	assert.Equal(t, "2.7", config.Get("CS"))
+}
@@ -0,0 +1,232 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/gainref"
+)
+
+// ValidateValue checks value against the rules of the key, empty values mean not set and are valid
+func ValidateValue(name string, value string) error {
+	name = canonicalName(name)
+	if value == "" {
+		return nil
+	}
+	switch name {
+	case "CS":
+		_, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("CS must be a number in mm, got %q", value)
+		}
+	case "Gainref_FlipRotate":
+		_, err := gainref.Parse(value)
+		if err != nil {
+			return fmt.Errorf("Gainref_FlipRotate: %w", err)
+		}
+	case "MPCPATH":
+	default:
+		return fmt.Errorf("unknown config key %q", name)
+	}
+	return nil
+}
+
+// Validate checks the values of the config file and of all its profiles
+func Validate(path string) []error {
+	var errs []error
+	values, profiles, err := ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	for _, name := range sortedNames(values) {
+		if err := ValidateValue(name, values[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	rules, err := ReadPathMappings(path)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pathMappingsKey, err))
+		}
+	}
+	windowsRules, err := ReadWindowsPaths(path)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, rule := range windowsRules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", windowsPathsKey, err))
+		}
+	}
+	for _, profileName := range sortedNames(profiles) {
+		profile := profiles[profileName]
+		for _, name := range sortedNames(profile.Values) {
+			if err := ValidateValue(name, profile.Values[name]); err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", profileName, err))
+			}
+		}
+	}
+	return errs
+}
+
+func sortedNames[V any](values map[string]V) []string {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readRaw reads the config file as generic json, keeping e.g. the profiles; a missing file is empty
+func readRaw(path string) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return raw, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return raw, nil
+}
+
+// writeRaw writes the config file via a temporary file, so a failed write never leaves a broken config behind
+func writeRaw(path string, raw map[string]interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(raw, "", "    ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(append(content, '\n'))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// section returns the map holding the keys of profile ("" for the top level), created if asked to
+func section(raw map[string]interface{}, profile string, create bool) (map[string]interface{}, error) {
+	if profile == "" {
+		return raw, nil
+	}
+	var profiles map[string]interface{}
+	for name, value := range raw {
+		if strings.EqualFold(name, profilesKey) {
+			var ok bool
+			profiles, ok = value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object", profilesKey)
+			}
+		}
+	}
+	if profiles == nil {
+		if !create {
+			return nil, fmt.Errorf("no instrument profile %q", profile)
+		}
+		profiles = make(map[string]interface{})
+		raw[profilesKey] = profiles
+	}
+	values, exists := profiles[profile]
+	if !exists {
+		if !create {
+			return nil, fmt.Errorf("no instrument profile %q", profile)
+		}
+		values = make(map[string]interface{})
+		profiles[profile] = values
+	}
+	sectionValues, ok := values.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("profile %s is not an object", profile)
+	}
+	return sectionValues, nil
+}
+
+// SetValue validates and stores a key in the config file, or in one of its profiles
+func SetValue(path string, profile string, name string, value string) error {
+	if err := ValidateValue(name, value); err != nil {
+		return err
+	}
+	raw, err := readRaw(path)
+	if err != nil {
+		return err
+	}
+	values, err := section(raw, profile, true)
+	if err != nil {
+		return err
+	}
+	for existing := range values {
+		if strings.EqualFold(canonicalName(existing), canonicalName(name)) {
+			delete(values, existing)
+		}
+	}
+	values[canonicalName(name)] = strings.TrimSpace(value)
+	return writeRaw(path, raw)
+}
+
+// UnsetValue removes a key from the config file, or from one of its profiles
+func UnsetValue(path string, profile string, name string) error {
+	raw, err := readRaw(path)
+	if err != nil {
+		return err
+	}
+	values, err := section(raw, profile, false)
+	if err != nil {
+		return err
+	}
+	found := false
+	for existing := range values {
+		if strings.EqualFold(canonicalName(existing), canonicalName(name)) {
+			delete(values, existing)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s is not set", name)
+	}
+	return writeRaw(path, raw)
+}
+
+// Import replaces the config file with the content of source after validating it
+func Import(path string, source string) error {
+	errs := Validate(source)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s is not a valid config: %w", source, errs[0])
+	}
+	raw, err := readRaw(source)
+	if err != nil {
+		return err
+	}
+	return writeRaw(path, raw)
+}
+
+// Export returns the config file as indented json, an empty object if there is none
+func Export(path string) ([]byte, error) {
+	raw, err := readRaw(path)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(raw, "", "    ")
+}
@@ -0,0 +1,72 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateValue(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{key: "CS", value: "2.7"},
+		{key: "cs", value: " 0.01 "},
+		{key: "CS", value: "2.7mm", wantErr: true},
+		{key: "Gainref_FlipRotate", value: "FlipX"},
+		{key: "gain_flip_rotate", value: "rotate90_flipy"},
+		{key: "Gainref_FlipRotate", value: "upside down", wantErr: true},
+		{key: "MPCPATH", value: "/mnt/epu/"},
+		{key: "MPCPATH", value: ""},
+		{key: "Voltage", value: "300", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			err := ValidateValue(tt.key, tt.value)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestSetUnsetValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf", "oscem-extractor-life.conf")
+	assert.NoError(t, SetValue(path, "", "cs", "2.7"))
+	assert.NoError(t, SetValue(path, "krios", "MPCPATH", "/mnt/krios/"))
+	assert.NoError(t, SetValue(path, "", "CS", "0.01"))
+	assert.Error(t, SetValue(path, "", "CS", "none"))
+
+	values, profiles, err := ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"CS": "0.01"}, values)
+	assert.Equal(t, "/mnt/krios/", profiles["krios"].Values["MPCPATH"])
+
+	assert.NoError(t, UnsetValue(path, "krios", "MPCPATH"))
+	assert.Error(t, UnsetValue(path, "krios", "MPCPATH"))
+	assert.Error(t, UnsetValue(path, "glacios", "CS"))
+	assert.Empty(t, Validate(path))
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "oscem-extractor-life.conf")
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	err := os.WriteFile(valid, []byte(`{"CS": "2.7", "Profiles": {"krios": {"Gainref_FlipRotate": "flipy", "Match": {"Camera0": "K3"}}}}`), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(invalid, []byte(`{"CS": "2.7", "Profiles": {"krios": {"Gainref_FlipRotate": "sideways"}}}`), 0644)
+	assert.NoError(t, err)
+
+	assert.Error(t, Import(path, invalid))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, Import(path, valid))
+	config, err := Load(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "2.7", config.Get("CS"))
+	assert.Equal(t, map[string]string{"Camera0": "K3"}, config.Profiles["krios"].Match)
+}
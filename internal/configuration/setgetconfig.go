@@ -2,22 +2,21 @@ package configuration
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/gainref"
 )
 
-func Getconfig() ([]byte, error) {
+// Getconfig returns the content of the config file at path, "" for DefaultPath
+func Getconfig(path string) ([]byte, error) {
 
-	path, err := os.UserConfigDir()
+	configFilePath, err := configPath(path)
 	if err != nil {
 		return nil, err
 	}
-	destination := filepath.Join("oscem-extractor-life", "oscem-extractor-life.conf")
-	configFilePath := filepath.Join(path, destination)
 
 	_, err1 := os.Stat(configFilePath)
 	if err1 != nil {
@@ -30,22 +29,13 @@ func Getconfig() ([]byte, error) {
 	return content, nil
 }
 
-func Changeconfig() {
+// Changeconfig asks for the config values on stdin and writes them to the config file at path, "" for DefaultPath
+func Changeconfig(path string) {
 
-	path, err := os.UserConfigDir()
+	configFilePath, err := configPath(path)
 	if err != nil {
 		fmt.Println("Couldn't reach config directory", err)
-	}
-	destination := filepath.Join("oscem-extractor-life", "oscem-extractor-life.conf")
-	configFilePath := filepath.Join(path, destination)
-	configdir := filepath.Join(path, "oscem-extractor-life")
-	_, err1 := os.Stat(configdir)
-	if err1 != nil {
-		if os.IsNotExist(err1) {
-			os.Mkdir(configdir, 0755)
-		} else {
-			fmt.Printf("Error accessing %q: %v\n", configdir, err1)
-		}
+		return
 	}
 	fmt.Println("What is your instruments spherical aberration (CS)?")
 	reader := bufio.NewReader(os.Stdin)
@@ -54,7 +44,8 @@ func Changeconfig() {
 		fmt.Fprintln(os.Stderr, "Error reading input:", err)
 		return
 	}
-	fmt.Println("And what is the rotation or flipping that needs to be done when importing the gain reference to e.g cryosparc?")
+	fmt.Println("And what is the rotation or flipping that needs to be done to the gain reference to match the data? One of", strings.Join(gainref.Names(), ", "))
+	fmt.Println("or in the notation of a package: relion:gain_rot=<0-3>,gain_flip=<0-2>, cryosparc:flip_x=<0|1>,flip_y=<0|1>,rotate=<0-3> or imod:<0-7>")
 	reader2 := bufio.NewReader(os.Stdin)
 	input2, err := reader2.ReadString('\n')
 	if err != nil {
@@ -68,19 +59,41 @@ func Changeconfig() {
 		fmt.Fprintln(os.Stderr, "Error reading input:", err)
 		return
 	}
-	configmap := make(map[string]string)
-	configmap["CS"] = strings.TrimSpace(input1)
-	configmap["Gainref_FlipRotate"] = strings.TrimSpace(input2)
-	configmap["MPCPATH"] = strings.TrimSpace(input3)
-	config, err := json.MarshalIndent(configmap, "", "    ")
+	// keep everything else of an existing config, e.g. the instrument profiles
+	configmap, err := readRaw(configFilePath)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error generating config:", err)
+		fmt.Fprintln(os.Stderr, "Error reading the existing config:", err)
 		return
 	}
+	for name := range configmap {
+		if canonicalName(name) != name {
+			delete(configmap, name)
+		}
+	}
+	answers := map[string]string{
+		"CS":                 strings.TrimSpace(input1),
+		"Gainref_FlipRotate": strings.TrimSpace(input2),
+		"MPCPATH":            strings.TrimSpace(input3),
+	}
+	for name, value := range answers {
+		if err := ValidateValue(name, value); err != nil {
+			fmt.Fprintln(os.Stderr, "Error generating config:", err)
+			return
+		}
+		configmap[name] = value
+	}
 
-	err = os.WriteFile(configFilePath, config, 0644)
+	err = writeRaw(configFilePath, configmap)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error generating config:", err)
+		return
 	}
 	fmt.Println("Generated config at", configFilePath)
 }
+
+func configPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	return DefaultPath()
+}
@@ -0,0 +1,287 @@
+// Package gainref models the orientation of a gain reference relative to the movies.
+//
+// A Transform is one of the eight rotations/flips of a square image. It is stored as a
+// counter-clockwise rotation by 0, 90, 180 or 270 degrees followed by an optional flip in X
+// (mirroring left-right, x -> -x). Flip Y mirrors top-bottom (y -> -y).
+//
+// The processing packages use different conventions, all of them are accepted by Parse:
+//
+//	none, flipx, rotate90_flipy, rot270 ...  steps applied from left to right
+//	relion:gain_rot=1,gain_flip=2            RELION/MotionCor2: rotate gain_rot*90° counter-clockwise,
+//	                                         then flip 1 upside down (Y) or 2 left-right (X)
+//	cryosparc:flip_x=1,flip_y=0,rotate=1     cryoSPARC import: flip first, then rotate rotate*90° counter-clockwise
+//	imod:5                                   IMOD/SerialEM RotationAndFlip: 0-3 rotate n*90° counter-clockwise,
+//	                                         4-7 flip around the Y axis (left-right) first, then rotate (n-4)*90°
+package gainref
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Transform struct {
+	Rotation int  // degrees counter-clockwise, 0, 90, 180 or 270
+	FlipX    bool // applied after the rotation
+}
+
+// None leaves the gain reference as it is
+var None = Transform{}
+
+// matrix acting on (x, y) column vectors
+type matrix [2][2]int
+
+var (
+	identity = matrix{{1, 0}, {0, 1}}
+	rotate90 = matrix{{0, -1}, {1, 0}}
+	flipX    = matrix{{-1, 0}, {0, 1}}
+	flipY    = matrix{{1, 0}, {0, -1}}
+)
+
+func (a matrix) times(b matrix) matrix {
+	var c matrix
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			c[i][j] = a[i][0]*b[0][j] + a[i][1]*b[1][j]
+		}
+	}
+	return c
+}
+
+func rotation(quarterTurns int) matrix {
+	m := identity
+	for i := 0; i < ((quarterTurns%4)+4)%4; i++ {
+		m = rotate90.times(m)
+	}
+	return m
+}
+
+func (t Transform) matrix() matrix {
+	m := rotation(t.Rotation / 90)
+	if t.FlipX {
+		m = flipX.times(m)
+	}
+	return m
+}
+
+func fromMatrix(m matrix) Transform {
+	for _, flip := range []bool{false, true} {
+		for rot := 0; rot < 360; rot += 90 {
+			t := Transform{Rotation: rot, FlipX: flip}
+			if t.matrix() == m {
+				return t
+			}
+		}
+	}
+	// not reachable for products of rotations and flips
+	return None
+}
+
+// Then returns the transform that applies t first and next afterwards
+func (t Transform) Then(next Transform) Transform {
+	return fromMatrix(next.matrix().times(t.matrix()))
+}
+
+// Inverse undoes t
+func (t Transform) Inverse() Transform {
+	for _, candidate := range All() {
+		if candidate.Then(t) == None {
+			return candidate
+		}
+	}
+	return None
+}
+
+// All lists the eight transforms in the order of their IMOD codes
+func All() []Transform {
+	var all []Transform
+	for code := 0; code < 8; code++ {
+		all = append(all, FromIMOD(code))
+	}
+	return all
+}
+
+// String is the canonical name, as stored in the config and passed on to the OSC-EM output
+func (t Transform) String() string {
+	switch {
+	case t == None:
+		return "none"
+	case t.FlipX && t.Rotation == 0:
+		return "flipx"
+	case t.FlipX && t.Rotation == 180:
+		return "flipy"
+	case t.FlipX:
+		return fmt.Sprintf("rotate%d_flipx", t.Rotation)
+	default:
+		return fmt.Sprintf("rotate%d", t.Rotation)
+	}
+}
+
+// Names are the canonical names of all transforms
+func Names() []string {
+	var names []string
+	for _, t := range All() {
+		names = append(names, t.String())
+	}
+	return names
+}
+
+// Parse reads a transform in any of the supported conventions, see the package documentation
+func Parse(value string) (Transform, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return None, fmt.Errorf("no gain reference transform given")
+	}
+	if convention, parameters, found := strings.Cut(value, ":"); found {
+		switch convention {
+		case "relion":
+			return parseRelion(parameters)
+		case "cryosparc":
+			return parseCryoSPARC(parameters)
+		case "imod", "serialem":
+			code, err := strconv.Atoi(strings.TrimSpace(parameters))
+			if err != nil || code < 0 || code > 7 {
+				return None, fmt.Errorf("IMOD RotationAndFlip must be 0-7, got %q", parameters)
+			}
+			return FromIMOD(code), nil
+		default:
+			return None, fmt.Errorf("unknown gain reference convention %q, use relion, cryosparc or imod", convention)
+		}
+	}
+	m := identity
+	for _, step := range strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune("_-+, ", r) }) {
+		switch step {
+		case "none", "identity", "rotate0", "rot0":
+		case "flipx":
+			m = flipX.times(m)
+		case "flipy":
+			m = flipY.times(m)
+		default:
+			degrees := strings.TrimPrefix(strings.TrimPrefix(step, "rotate"), "rot")
+			angle, err := strconv.Atoi(degrees)
+			if degrees == step || err != nil || angle%90 != 0 {
+				return None, fmt.Errorf("unknown gain reference transform %q, use one of %s or relion:, cryosparc:, imod: settings",
+					value, strings.Join(Names(), ", "))
+			}
+			m = rotation(angle / 90).times(m)
+		}
+	}
+	return fromMatrix(m), nil
+}
+
+func parameterMap(parameters string) (map[string]int, error) {
+	values := make(map[string]int)
+	for _, parameter := range strings.Split(parameters, ",") {
+		name, value, found := strings.Cut(parameter, "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not of the form name=value", parameter)
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer, got %q", name, value)
+		}
+		values[strings.TrimSpace(name)] = number
+	}
+	return values, nil
+}
+
+func parseRelion(parameters string) (Transform, error) {
+	values, err := parameterMap(parameters)
+	if err != nil {
+		return None, err
+	}
+	rot, flip := values["gain_rot"], values["gain_flip"]
+	if rot < 0 || rot > 3 || flip < 0 || flip > 2 {
+		return None, fmt.Errorf("RELION gain_rot must be 0-3 and gain_flip 0-2")
+	}
+	m := rotation(rot)
+	switch flip {
+	case 1:
+		m = flipY.times(m)
+	case 2:
+		m = flipX.times(m)
+	}
+	return fromMatrix(m), nil
+}
+
+func parseCryoSPARC(parameters string) (Transform, error) {
+	values, err := parameterMap(parameters)
+	if err != nil {
+		return None, err
+	}
+	rotate := values["rotate"]
+	if rotate < 0 || rotate > 3 {
+		return None, fmt.Errorf("cryoSPARC rotate must be 0-3")
+	}
+	m := identity
+	if values["flip_x"] != 0 {
+		m = flipX.times(m)
+	}
+	if values["flip_y"] != 0 {
+		m = flipY.times(m)
+	}
+	return fromMatrix(rotation(rotate).times(m)), nil
+}
+
+// FromIMOD converts an IMOD/SerialEM RotationAndFlip code (0-7)
+func FromIMOD(code int) Transform {
+	m := identity
+	if code >= 4 {
+		m = flipX
+	}
+	return fromMatrix(rotation(code % 4).times(m))
+}
+
+// IMOD returns the RotationAndFlip code
+func (t Transform) IMOD() int {
+	for code := 0; code < 8; code++ {
+		if FromIMOD(code) == t {
+			return code
+		}
+	}
+	return 0
+}
+
+// Relion returns the gain_rot and gain_flip settings, preferring no flip, then flip X
+func (t Transform) Relion() (int, int) {
+	for _, flip := range []int{0, 2, 1} {
+		for rot := 0; rot < 4; rot++ {
+			candidate, _ := parseRelion(fmt.Sprintf("gain_rot=%d,gain_flip=%d", rot, flip))
+			if candidate == t {
+				return rot, flip
+			}
+		}
+	}
+	return 0, 0
+}
+
+// CryoSPARC returns the flip X, flip Y and rotate settings of a cryoSPARC import, using at most one flip
+func (t Transform) CryoSPARC() (bool, bool, int) {
+	for _, flips := range [][2]int{{0, 0}, {1, 0}, {0, 1}} {
+		for rotate := 0; rotate < 4; rotate++ {
+			candidate, _ := parseCryoSPARC(fmt.Sprintf("flip_x=%d,flip_y=%d,rotate=%d", flips[0], flips[1], rotate))
+			if candidate == t {
+				return flips[0] == 1, flips[1] == 1, rotate
+			}
+		}
+	}
+	return false, false, 0
+}
+
+// Conventions lists the transform in the notation of each package, in a form Parse accepts
+func (t Transform) Conventions() map[string]string {
+	rot, flip := t.Relion()
+	flipXSet, flipYSet, rotate := t.CryoSPARC()
+	return map[string]string{
+		"relion":    fmt.Sprintf("relion:gain_rot=%d,gain_flip=%d", rot, flip),
+		"cryosparc": fmt.Sprintf("cryosparc:flip_x=%d,flip_y=%d,rotate=%d", boolInt(flipXSet), boolInt(flipYSet), rotate),
+		"imod":      fmt.Sprintf("imod:%d", t.IMOD()),
+	}
+}
+
+func boolInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
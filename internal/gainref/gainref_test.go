@@ -0,0 +1,63 @@
+package gainref
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "none", want: "none"},
+		{value: "FlipX", want: "flipx"},
+		{value: "flip-y", wantErr: true},
+		{value: "rot90", want: "rotate90"},
+		{value: "rotate90_flipy", want: "rotate270_flipx"},
+		{value: "flipx_flipy", want: "rotate180"},
+		{value: "rotate180_flipx", want: "flipy"},
+		{value: "rotate450", want: "rotate90"},
+		{value: "rotate45", wantErr: true},
+		{value: "upside down", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "relion:gain_rot=0,gain_flip=2", want: "flipx"},
+		{value: "relion:gain_rot=1,gain_flip=1", want: "rotate270_flipx"},
+		{value: "relion:gain_rot=4,gain_flip=0", wantErr: true},
+		{value: "cryosparc:flip_x=1,flip_y=0,rotate=1", want: "rotate270_flipx"},
+		{value: "cryosparc:flip_y=1", want: "flipy"},
+		{value: "imod:0", want: "none"},
+		{value: "imod:4", want: "flipx"},
+		{value: "imod:5", want: "rotate270_flipx"},
+		{value: "imod:8", wantErr: true},
+		{value: "eman:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			transform, err := Parse(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, transform.String())
+		})
+	}
+}
+
+func TestConventionsRoundTrip(t *testing.T) {
+	assert.Len(t, Names(), 8)
+	for _, transform := range All() {
+		parsed, err := Parse(transform.String())
+		assert.NoError(t, err)
+		assert.Equal(t, transform, parsed)
+		for convention, value := range transform.Conventions() {
+			parsed, err := Parse(value)
+			assert.NoError(t, err, convention)
+			assert.Equal(t, transform, parsed, value)
+		}
+		assert.Equal(t, None, transform.Then(transform.Inverse()))
+	}
+}
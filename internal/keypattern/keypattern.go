@@ -0,0 +1,18 @@
+// Package keypattern matches the flattened metadata keys against the patterns of the instrument profiles
+// and the quality rules.
+package keypattern
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile turns a key pattern into a regexp: * matches any characters, everything else literally
+// (keys contain [ ] and .)
+func Compile(key string) *regexp.Regexp {
+	parts := strings.Split(key, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
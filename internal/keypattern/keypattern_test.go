@@ -0,0 +1,24 @@
+package keypattern
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"Detectors[*].CommercialName", "Detectors[EF-Falcon].CommercialName", true},
+		{"Detectors[*].CommercialName", "Detectors[EF-Falcon].CommercialNames", false},
+		{"Detectors[EF-Falcon].DoseRate", "DetectorsXEF-Falcon]YDoseRate", false},
+		{"*Voltage", "MicroscopeImage.microscopeData.gun.AccelerationVoltage", true},
+		{"Voltage", "Voltage_min", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, Compile(tt.pattern).MatchString(tt.key), tt.pattern+" "+tt.key)
+	}
+}
@@ -0,0 +1,217 @@
+// Package manifest lists the files of a dataset with their size, modification time and checksum,
+// as csv, as BagIt payload manifest or as SciCat OrigDatablock.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	SHA256 = "sha256"
+	XXH64  = "xxh64"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatBagIt  = "bagit"
+	FormatSciCat = "scicat"
+)
+
+type Entry struct {
+	Path     string // relative to the dataset root, absolute for files outside of it
+	Size     int64
+	ModTime  time.Time
+	Checksum string
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case XXH64:
+		return xxhash.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %q, use %s or %s", algorithm, SHA256, XXH64)
+	}
+}
+
+// Check tells whether format and algorithm are known and go together, before any file is read
+func Check(format string, algorithm string) error {
+	if _, err := newHash(algorithm); err != nil {
+		return err
+	}
+	// BagIt only knows the registered digests, xxh64 is none of them
+	if format == FormatBagIt && algorithm != SHA256 {
+		return fmt.Errorf("the %s format needs the %s checksum, %s is no BagIt digest", FormatBagIt, SHA256, algorithm)
+	}
+	switch format {
+	case FormatCSV, FormatBagIt, FormatSciCat, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown manifest format %q, use %s, %s or %s", format, FormatCSV, FormatBagIt, FormatSciCat)
+	}
+}
+
+// Build checksums the files with at most workers files read at the same time. Files that can not
+// be read are reported in the error, the entries of all others are returned sorted by path.
+func Build(root string, files []string, algorithm string, workers int) ([]Entry, error) {
+	if _, err := newHash(algorithm); err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan string)
+	type result struct {
+		entry Entry
+		err   error
+	}
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				entry, err := checksum(root, file, algorithm)
+				results <- result{entry, err}
+			}
+		}()
+	}
+	go func() {
+		seen := make(map[string]bool)
+		for _, file := range files {
+			absolute, err := filepath.Abs(file)
+			if err != nil {
+				absolute = file
+			}
+			if !seen[absolute] {
+				seen[absolute] = true
+				jobs <- file
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var entries []Entry
+	var errs []error
+	for result := range results {
+		if result.err != nil {
+			errs = append(errs, result.err)
+			continue
+		}
+		entries = append(entries, result.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, errors.Join(errs...)
+}
+
+func checksum(root string, file string, algorithm string) (Entry, error) {
+	opened, err := os.Open(file)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer opened.Close()
+	info, err := opened.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+	hasher, _ := newHash(algorithm)
+	if _, err := io.Copy(hasher, opened); err != nil {
+		return Entry{}, fmt.Errorf("%s: %w", file, err)
+	}
+	return Entry{
+		Path:     relative(root, file),
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UTC(),
+		Checksum: hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+// Outside tells if file is not below root, its entry then keeps the absolute path
+func Outside(root string, file string) bool {
+	return filepath.IsAbs(relative(root, file))
+}
+
+func relative(root string, file string) string {
+	absolute, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	path, err := filepath.Rel(root, absolute)
+	if err != nil || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return absolute
+	}
+	return filepath.ToSlash(path)
+}
+
+// Write writes the entries in one of the formats: csv with all fields, a BagIt manifest-<algorithm>.txt
+// (payload paths below data/, files outside of the root are rejected) or a SciCat OrigDatablock with the dataFileList
+func Write(w io.Writer, entries []Entry, format string, algorithm string) error {
+	switch format {
+	case FormatCSV, "":
+		writer := csv.NewWriter(w)
+		rows := [][]string{{"path", "size", "mtime", algorithm}}
+		for _, entry := range entries {
+			rows = append(rows, []string{entry.Path, strconv.FormatInt(entry.Size, 10), entry.ModTime.Format(time.RFC3339), entry.Checksum})
+		}
+		return writer.WriteAll(rows)
+	case FormatBagIt:
+		if err := Check(format, algorithm); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if filepath.IsAbs(entry.Path) {
+				return fmt.Errorf("%s is outside of the dataset, a BagIt payload has to be below data/", entry.Path)
+			}
+			path := "data/" + entry.Path
+			// BagIt percent-encodes line breaks and % in paths
+			path = strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D").Replace(path)
+			if _, err := fmt.Fprintf(w, "%s  %s\n", entry.Checksum, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatSciCat:
+		type dataFile struct {
+			Path string `json:"path"`
+			Size int64  `json:"size"`
+			Time string `json:"time"`
+			Chk  string `json:"chk"`
+		}
+		datablock := struct {
+			Size         int64      `json:"size"`
+			ChkAlg       string     `json:"chkAlg"`
+			DataFileList []dataFile `json:"dataFileList"`
+		}{ChkAlg: algorithm, DataFileList: []dataFile{}}
+		for _, entry := range entries {
+			datablock.Size += entry.Size
+			datablock.DataFileList = append(datablock.DataFileList, dataFile{
+				Path: entry.Path, Size: entry.Size, Time: entry.ModTime.Format(time.RFC3339), Chk: entry.Checksum,
+			})
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(datablock)
+	default:
+		return fmt.Errorf("unknown manifest format %q, use %s, %s or %s", format, FormatCSV, FormatBagIt, FormatSciCat)
+	}
+}
@@ -0,0 +1,90 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "movie.eer")
+	files := []string{filepath.Join(root, "Data", "a.xml"), filepath.Join(root, "b.mdoc"), outside}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stamp := time.Date(2024, 9, 1, 6, 1, 8, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(files[0], stamp, stamp))
+
+	tests := []struct {
+		algorithm string
+		want      string
+	}{
+		{algorithm: SHA256, want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{algorithm: XXH64, want: "44bc2cf5ad770999"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			// duplicates are checksummed once
+			entries, err := Build(root, append(files, files[1]), tt.algorithm, 2)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 3)
+			assert.Equal(t, "Data/a.xml", entries[1].Path)
+			assert.Equal(t, "b.mdoc", entries[2].Path)
+			assert.Equal(t, outside, entries[0].Path)
+			assert.Equal(t, int64(3), entries[1].Size)
+			assert.Equal(t, stamp, entries[1].ModTime)
+			for _, entry := range entries {
+				assert.Equal(t, tt.want, entry.Checksum)
+			}
+		})
+	}
+
+	_, err := Build(root, []string{filepath.Join(root, "missing.eer")}, SHA256, 1)
+	assert.Error(t, err)
+	_, err = Build(root, files, "md5", 1)
+	assert.Error(t, err)
+}
+
+func TestWrite(t *testing.T) {
+	stamp := time.Date(2024, 9, 1, 6, 1, 8, 0, time.UTC)
+	entries := []Entry{
+		{Path: "Data/a.xml", Size: 3, ModTime: stamp, Checksum: "aa"},
+		{Path: "Data/100%.eer", Size: 5, ModTime: stamp, Checksum: "bb"},
+	}
+
+	var csvOut bytes.Buffer
+	assert.NoError(t, Write(&csvOut, entries, FormatCSV, SHA256))
+	assert.Equal(t, "path,size,mtime,sha256\nData/a.xml,3,2024-09-01T06:01:08Z,aa\nData/100%.eer,5,2024-09-01T06:01:08Z,bb\n", csvOut.String())
+
+	var bagit bytes.Buffer
+	assert.NoError(t, Write(&bagit, entries, FormatBagIt, SHA256))
+	assert.Equal(t, "aa  data/Data/a.xml\nbb  data/Data/100%25.eer\n", bagit.String())
+	outside := append(entries, Entry{Path: "/mnt/frames/c.tif", Size: 1, ModTime: stamp, Checksum: "cc"})
+	assert.Error(t, Write(&bagit, outside, FormatBagIt, SHA256))
+	assert.Error(t, Write(&bytes.Buffer{}, entries, FormatBagIt, XXH64))
+	assert.Error(t, Check(FormatBagIt, XXH64))
+	assert.NoError(t, Check(FormatCSV, XXH64))
+
+	var scicat bytes.Buffer
+	assert.NoError(t, Write(&scicat, entries, FormatSciCat, XXH64))
+	var datablock map[string]interface{}
+	assert.NoError(t, json.Unmarshal(scicat.Bytes(), &datablock))
+	assert.Equal(t, float64(8), datablock["size"])
+	assert.Equal(t, "xxh64", datablock["chkAlg"])
+	assert.Len(t, datablock["dataFileList"], 2)
+	assert.True(t, strings.Contains(scicat.String(), `"time": "2024-09-01T06:01:08Z"`))
+
+	assert.Error(t, Write(&scicat, entries, "zip", SHA256))
+}
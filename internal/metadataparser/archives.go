@@ -0,0 +1,83 @@
+package metadataparser
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/archive"
+)
+
+// extensions of the session files kept next to the metadata: EPU/Tomo sessions, atlas and
+// grid square .dm files and SerialEM navigators
+var sessionExtensions = []string{".dm", ".nav"}
+
+func isSessionFile(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	for _, sessionExtension := range sessionExtensions {
+		if extension == sessionExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionFiles finds the session files below the roots
+func sessionFiles(roots []string) []string {
+	var files []string
+	for _, root := range roots {
+		_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() && isHidden(entry.Name()) && path != root {
+				return filepath.SkipDir
+			}
+			if !entry.IsDir() && !isHidden(entry.Name()) && isSessionFile(entry.Name()) {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// archiveFiles names the files relative to the first root holding them. EPU mirrors the layout of
+// the dataset, so a file of the mirror and its copy in the dataset share a name and are archived once.
+// The files are ordered by root and path, so the archive keeps the copy of the first root (the dataset)
+// whatever order the files were read in.
+func archiveFiles(files []string, roots []string) []archive.File {
+	var archived []archive.File
+	ranks := make(map[string]int)
+	for _, file := range files {
+		absolute, err := filepath.Abs(file)
+		if err != nil {
+			absolute = file
+		}
+		name := filepath.Base(absolute)
+		rank := len(roots)
+		for i, root := range roots {
+			relative, err := filepath.Rel(root, absolute)
+			if err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+				name, rank = relative, i
+				break
+			}
+		}
+		ranks[absolute] = rank
+		archived = append(archived, archive.File{Name: filepath.ToSlash(name), Path: absolute})
+	}
+	sort.SliceStable(archived, func(i, j int) bool {
+		if ranks[archived[i].Path] != ranks[archived[j].Path] {
+			return ranks[archived[i].Path] < ranks[archived[j].Path]
+		}
+		return archived[i].Path < archived[j].Path
+	})
+	return archived
+}
+
+// writeArchive packs the metadata files that were read and the session files of the dataset and its mirror
+func writeArchive(path string, format string, level int, metadataFiles []string, roots []string) error {
+	files := append(append([]string{}, metadataFiles...), sessionFiles(roots)...)
+	return archive.Write(path, archiveFiles(files, roots), format, level)
+}
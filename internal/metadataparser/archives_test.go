@@ -0,0 +1,44 @@
+package metadataparser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osc-em/oscem-extractor-life/internal/archive"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveFiles(t *testing.T) {
+	dataset, mirror, elsewhere := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, path := range []string{
+		filepath.Join(mirror, "EpuSession.dm"),
+		filepath.Join(mirror, "Metadata", "GridSquare_1.dm"),
+		filepath.Join(mirror, ".hidden", "x.dm"),
+		filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data", "a.xml"),
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+	roots := []string{dataset, mirror}
+	sessions := sessionFiles(roots)
+	assert.Equal(t, []string{filepath.Join(mirror, "EpuSession.dm"), filepath.Join(mirror, "Metadata", "GridSquare_1.dm")}, sessions)
+
+	files := archiveFiles(append([]string{
+		filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data", "a.xml"),
+		filepath.Join(elsewhere, "b.mdoc"),
+	}, sessions...), roots)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{"Images-Disc1/GridSquare_1/Data/a.xml", "EpuSession.dm", "Metadata/GridSquare_1.dm", "b.mdoc"}, names)
+
+	// the copy in the dataset is archived, in whatever order the files were read
+	copied := filepath.Join(dataset, "EpuSession.dm")
+	assert.NoError(t, os.WriteFile(copied, []byte("dataset"), 0644))
+	for _, order := range [][]string{{filepath.Join(mirror, "EpuSession.dm"), copied}, {copied, filepath.Join(mirror, "EpuSession.dm")}} {
+		files = archiveFiles(order, roots)
+		assert.Equal(t, archive.File{Name: "EpuSession.dm", Path: copied}, files[0])
+	}
+}
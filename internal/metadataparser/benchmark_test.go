@@ -0,0 +1,76 @@
+package metadataparser
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+)
+
+// syntheticSession copies the test xmls into a dataset of files metadata files, spread over grid squares
+// of 100 files like EPU does
+func syntheticSession(b *testing.B, files int) string {
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	var contents [][]byte
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			b.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+	dataset := b.TempDir()
+	for i := 0; i < files; i++ {
+		data := filepath.Join(dataset, "Images-Disc1", fmt.Sprintf("GridSquare_%d", i/100), "Data")
+		if err := os.MkdirAll(data, 0755); err != nil {
+			b.Fatal(err)
+		}
+		name := fmt.Sprintf("FoilHole_%d_Data_%d_%d_20240901_060108.xml", i/10, i, i)
+		if err := os.WriteFile(filepath.Join(data, name), contents[i%len(contents)], 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dataset
+}
+
+func BenchmarkExtract(b *testing.B) {
+	for _, files := range []int{200, 1000} {
+		dataset := syntheticSession(b, files)
+		for _, workers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("files=%d/workers=%d", files, workers), func(b *testing.B) {
+				opts := Options{
+					PathMappings: []pathmap.Rule{},
+					WindowsPaths: []pathmap.WindowsRule{},
+					Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+					Workers:      workers,
+				}
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := Extract(dataset, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(files*b.N)/b.Elapsed().Seconds(), "files/s")
+			})
+		}
+	}
+}
+
+// BenchmarkCollection merges the same file over and over, the memory per file stays that of its movie record
+func BenchmarkCollection(b *testing.B) {
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	file, err := parseFile(sources[0])
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := newCollection(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.add(file)
+	}
+}
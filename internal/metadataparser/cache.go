@@ -0,0 +1,217 @@
+package metadataparser
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// cacheVersion is stored with the cache, a cache of another version is discarded. Increase it whenever
+// process_xml, process_mdoc or the movie records change what they return for the same file.
+const cacheVersion = 6
+
+// cacheEntry is the parsed content of a file, valid as long as the file has the same size and
+// modification time, or the same size and content hash
+type cacheEntry struct {
+	Size    int64
+	ModTime time.Time
+	Hash    uint64
+	Mdoc    bool
+	Content map[string]string
+	Movies  []movieRecord
+}
+
+type cacheFile struct {
+	Version int
+	Entries map[string]cacheEntry
+}
+
+// fileCache keeps the parsed metadata files of one dataset between runs, so only new and changed
+// files are parsed again
+type fileCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	changed bool
+	// hits and misses of this run
+	hits   int
+	misses int
+}
+
+// DefaultCacheDir is the cache directory below the user cache directory
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "oscem-extractor-life"), nil
+}
+
+// cachePath names the cache of a dataset after its absolute path, datasets sharing a folder name do not collide
+func cachePath(dir string, dataset string) string {
+	sum := sha256.Sum256([]byte(dataset))
+	return filepath.Join(dir, filepath.Base(dataset)+"-"+hex.EncodeToString(sum[:8])+".gob")
+}
+
+// openCache reads the cache at path, a missing, unreadable or outdated cache starts empty
+func openCache(path string, log *slog.Logger) *fileCache {
+	cache := &fileCache{path: path, entries: make(map[string]cacheEntry)}
+	opened, err := os.Open(path)
+	if err != nil {
+		return cache
+	}
+	defer opened.Close()
+	var stored cacheFile
+	if err := gob.NewDecoder(opened).Decode(&stored); err != nil {
+		log.Warn("Ignoring the unreadable cache", "path", path, "error", err)
+		return cache
+	}
+	if stored.Version != cacheVersion {
+		log.Warn("Ignoring the cache of an older version, all files are parsed again", "path", path)
+		return cache
+	}
+	if stored.Entries != nil {
+		cache.entries = stored.Entries
+	}
+	return cache
+}
+
+func hashFile(path string) (uint64, error) {
+	opened, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer opened.Close()
+	hasher := xxhash.New()
+	if _, err := io.Copy(hasher, opened); err != nil {
+		return 0, err
+	}
+	return hasher.Sum64(), nil
+}
+
+// parse returns the cached content of the file if it is unchanged, parses and stores it otherwise
+func (cache *fileCache) parse(filePath string) (parsedFile, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return parsedFile{}, err
+	}
+	key := cacheKey(filePath)
+	cache.mu.Lock()
+	entry, cached := cache.entries[key]
+	cache.mu.Unlock()
+	var hash uint64
+	hashed := false
+	if cached && entry.Size == info.Size() && !entry.ModTime.Equal(info.ModTime()) {
+		// touched or copied without keeping the time, the content decides
+		hash, err = hashFile(filePath)
+		hashed = err == nil
+		if hashed && hash == entry.Hash {
+			entry.ModTime = info.ModTime()
+			cache.store(key, entry, true)
+			return entry.parsedFile(filePath), nil
+		}
+	} else if cached && entry.Size == info.Size() {
+		cache.store(key, entry, false)
+		return entry.parsedFile(filePath), nil
+	}
+
+	parsed, err := parseFile(filePath)
+	if err != nil {
+		return parsed, err
+	}
+	if !hashed {
+		hash, err = hashFile(filePath)
+		if err != nil {
+			return parsed, nil
+		}
+	}
+	cache.miss(key, cacheEntry{
+		Size: info.Size(), ModTime: info.ModTime(), Hash: hash, Mdoc: parsed.mdoc, Content: parsed.content, Movies: parsed.movies,
+	})
+	return parsed, nil
+}
+
+// cacheKey is the absolute path, the same file is found from any working directory
+func cacheKey(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return absolute
+}
+
+func (entry cacheEntry) parsedFile(path string) parsedFile {
+	// the movies are changed by the extraction, the cached ones are kept as they are; the file may
+	// have been found from another working directory
+	movies := append([]movieRecord{}, entry.Movies...)
+	for i := range movies {
+		movies[i].Source = path
+	}
+	return parsedFile{path: path, content: entry.Content, movies: movies, mdoc: entry.Mdoc}
+}
+
+func (cache *fileCache) store(path string, entry cacheEntry, changed bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[path] = entry
+	cache.changed = cache.changed || changed
+	cache.hits++
+}
+
+func (cache *fileCache) miss(path string, entry cacheEntry) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[path] = entry
+	cache.changed = true
+	cache.misses++
+}
+
+// retain drops the entries of files that are gone
+func (cache *fileCache) retain(files []string) {
+	keep := make(map[string]bool, len(files))
+	for _, file := range files {
+		keep[cacheKey(file)] = true
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for path := range cache.entries {
+		if !keep[path] {
+			delete(cache.entries, path)
+			cache.changed = true
+		}
+	}
+}
+
+// save writes the cache if anything changed, via a temporary file so an interrupted run keeps the old cache
+func (cache *fileCache) save() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if !cache.changed {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(cache.path), filepath.Base(cache.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	err = gob.NewEncoder(temp).Encode(cacheFile{Version: cacheVersion, Entries: cache.entries})
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	cache.changed = false
+	return os.Rename(temp.Name(), cache.path)
+}
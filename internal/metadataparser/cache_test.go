@@ -0,0 +1,86 @@
+package metadataparser
+
+import (
+	"context"
+	"encoding/gob"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	var files []string
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		file := filepath.Join(data, filepath.Base(source))
+		assert.NoError(t, os.WriteFile(file, content, 0644))
+		files = append(files, file)
+	}
+	path := filepath.Join(t.TempDir(), "cache.gob")
+	run := func() (*fileCache, []parsedFile) {
+		cache := openCache(path, slog.Default())
+		var parsed []parsedFile
+		parseFiles(context.Background(), files, 2, 0, &progressReporter{}, cache.parse, func(file parsedFile) { parsed = append(parsed, file) })
+		cache.retain(files)
+		assert.NoError(t, cache.save())
+		return cache, parsed
+	}
+
+	cache, first := run()
+	assert.Equal(t, 0, cache.hits)
+	assert.Equal(t, 2, cache.misses)
+
+	// touched without changing the content
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(files[0], later, later))
+	cache, second := run()
+	assert.Equal(t, 2, cache.hits)
+	assert.Equal(t, len(first), len(second))
+	byPath := make(map[string]parsedFile)
+	for _, file := range first {
+		byPath[file.path] = file
+	}
+	for _, file := range second {
+		assert.Equal(t, byPath[file.path], file)
+	}
+
+	// changed content of the same size is parsed again
+	content, _ := os.ReadFile(files[1])
+	content = []byte(strings.Replace(string(content), "2024", "2025", 1))
+	assert.NoError(t, os.WriteFile(files[1], content, 0644))
+	assert.NoError(t, os.Chtimes(files[1], later, later))
+	cache, _ = run()
+	assert.Equal(t, 1, cache.hits)
+	assert.Equal(t, 1, cache.misses)
+
+	// removed files are dropped, a cache of another version is ignored
+	files = files[:1]
+	cache, _ = run()
+	assert.Len(t, cache.entries, 1)
+	out, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, gob.NewEncoder(out).Encode(cacheFile{Version: cacheVersion + 1, Entries: cache.entries}))
+	out.Close()
+	assert.Empty(t, openCache(path, slog.Default()).entries)
+
+	// a cached run gives the same metadata
+	opts := Options{PathMappings: []pathmap.Rule{}, WindowsPaths: []pathmap.WindowsRule{}, CacheDir: t.TempDir()}
+	uncached, err := Extract(dataset, Options{PathMappings: []pathmap.Rule{}, WindowsPaths: []pathmap.WindowsRule{}})
+	assert.NoError(t, err)
+	_, err = Extract(dataset, opts)
+	assert.NoError(t, err)
+	cached, err := Extract(dataset, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, uncached.Metadata, cached.Metadata)
+}
@@ -0,0 +1,121 @@
+package metadataparser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeout(t *testing.T) {
+	hanging := make(chan struct{})
+	defer close(hanging)
+	parse := func(path string) (parsedFile, error) {
+		if path == "stalled.xml" {
+			<-hanging
+		}
+		return parsedFile{path: path}, nil
+	}
+	var parsed []parsedFile
+	add := func(file parsedFile) { parsed = append(parsed, file) }
+	failed := parseFiles(context.Background(), []string{"a.xml", "stalled.xml", "b.xml"}, 3, 20*time.Millisecond, &progressReporter{}, parse, add)
+	assert.Len(t, parsed, 2)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, "stalled.xml", failed[0].Path)
+	assert.Equal(t, "reading the file timed out after 20ms", failed[0].Reason)
+
+	// a cancelled run leaves the remaining files out without reporting them
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	parsed = nil
+	failed = parseFiles(ctx, []string{"a.xml", "b.xml"}, 0, 0, &progressReporter{}, parse, add)
+	assert.Empty(t, parsed)
+	assert.Empty(t, failed)
+}
+
+func TestExtractCancelled(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(source)), content, 0644))
+	}
+	opts := Options{
+		PathMappings: []pathmap.Rule{},
+		WindowsPaths: []pathmap.WindowsRule{},
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := ExtractContext(ctx, dataset, opts)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+
+	// cancelled once the first file is read
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	opts.OnProgress = func(event ProgressEvent) {
+		if event.Event == ProgressParsed {
+			cancel()
+		}
+	}
+	result, err = ExtractContext(ctx, dataset, opts)
+	assert.True(t, errors.Is(err, context.Canceled), err)
+	if assert.NotNil(t, result) {
+		assert.True(t, result.Incomplete)
+		assert.Equal(t, "true", result.merged["Incomplete"])
+	}
+}
+
+func TestWatchCancelled(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(source)), content, 0644))
+	}
+
+	// cancelled while the first poll reads the files, the poll stops and the state is written a last time
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := Options{
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Workers: 1,
+		OnProgress: func(event ProgressEvent) {
+			if event.Event == ProgressParsed {
+				cancel()
+			}
+		},
+	}
+	finals := 0
+	result, err := Watch(ctx, dataset, opts, WatchOptions{
+		PollInterval: time.Hour,
+		OnUpdate: func(result *Result, final bool) error {
+			if final {
+				finals++
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, finals)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "1", result.merged["NumberOfMovies"])
+		// the file left out was not tried, it is no failure
+		assert.Empty(t, result.Diagnostics)
+	}
+}
@@ -0,0 +1,60 @@
+package metadataparser
+
+import (
+	"os"
+	"sort"
+
+	"github.com/osc-em/oscem-extractor-life/internal/manifest"
+)
+
+// manifestFiles are the metadata files that were read, not those that failed, and the movies of the
+// dataset, including referenced movies found outside of it
+func manifestFiles(metadataFiles []string, resolver *movieResolver, movies []movieRecord) []string {
+	files := append([]string{}, metadataFiles...)
+	var below []string
+	for path := range movieFilesBelow(resolver.root) {
+		below = append(below, path)
+	}
+	sort.Strings(below)
+	files = append(files, below...)
+	for _, movie := range movies {
+		if movie.Path == "" {
+			continue
+		}
+		if found, exists := resolver.resolve(movie.Path, movie.Source); exists {
+			files = append(files, found)
+		}
+	}
+	return files
+}
+
+// bagPayload leaves out the files outside of root, a BagIt payload can only hold files below its data/
+func bagPayload(root string, files []string) ([]string, []string) {
+	var inside, outside []string
+	for _, file := range files {
+		if manifest.Outside(root, file) {
+			outside = append(outside, file)
+		} else {
+			inside = append(inside, file)
+		}
+	}
+	return inside, outside
+}
+
+// writeManifest checksums the files and writes the manifest, a failed file fails the whole manifest
+// as an incomplete list of checksums is of no use for archiving
+func writeManifest(path string, format string, algorithm string, workers int, root string, files []string) error {
+	entries, err := manifest.Build(root, files, algorithm, workers)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := manifest.Write(out, entries, format, algorithm); err != nil {
+		return err
+	}
+	return out.Close()
+}
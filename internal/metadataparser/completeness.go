@@ -0,0 +1,208 @@
+package metadataparser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrIncomplete = errors.New("dataset completeness is below the threshold")
+
+// file extensions of movies, in the order they are preferred when pairing an EPU xml
+var movieExtensions = []string{".eer", ".tiff", ".tif", ".mrc"}
+
+// name suffixes EPU adds to the movie of an acquisition, foo.xml belongs to foo_fractions.tiff or foo_EER.eer
+var movieSuffixes = []string{"_fractions", "_EER", ""}
+
+// CompletenessReport pairs every metadata entry (EPU xml, mdoc movie) with its movie on disk. The paths are
+// relative to the dataset root, paths outside of it and recorded SubFramePaths stay as they are.
+type CompletenessReport struct {
+	MetadataEntries int      `json:"metadataEntries"`
+	Paired          int      `json:"paired"`
+	Ratio           float64  `json:"ratio"`
+	MovieFiles      int      `json:"movieFiles"`
+	MovieBytes      int64    `json:"movieBytes"`
+	MissingMovies   []string `json:"missingMovies"`
+	OrphanMovies    []string `json:"orphanMovies"`
+	Pairs           []Pair   `json:"pairs"`
+}
+
+// Pair is a metadata file and the movie that belongs to it
+type Pair struct {
+	Metadata string `json:"metadata"`
+	Movie    string `json:"movie"`
+	Bytes    int64  `json:"bytes"`
+}
+
+func isMovie(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	for _, movieExtension := range movieExtensions {
+		if extension == movieExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// acquisitionStem is the name shared by the metadata and the movie of an acquisition
+func acquisitionStem(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	for _, suffix := range movieSuffixes {
+		if suffix != "" && strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+// checkCompleteness pairs the metadata with the movies below the dataset root. Movie files that share
+// the name of an acquisition without being its movie (e.g. the EPU averaged foo.mrc next to
+// foo_fractions.tiff) or are referenced as ImageFile are not counted as orphans.
+func checkCompleteness(resolver *movieResolver, movies []movieRecord, imageFiles []imageFileReference) *CompletenessReport {
+	report := &CompletenessReport{MissingMovies: []string{}, OrphanMovies: []string{}, Pairs: []Pair{}}
+	sizes := movieFilesBelow(resolver.root)
+
+	used := make(map[string]bool)
+	stacks := make(map[string]bool)
+	stems := make(map[string]bool)
+	for _, image := range imageFiles {
+		if found, exists := resolver.resolve(image.recorded, image.referrer); exists {
+			used[found] = true
+			stacks[found] = true
+		}
+	}
+	for _, movie := range movies {
+		frameMdoc := strings.HasSuffix(movie.Source, ".mdoc") && isMovie(strings.TrimSuffix(movie.Source, ".mdoc"))
+		if movie.Path == "" && strings.HasSuffix(movie.Source, ".mdoc") && !frameMdoc {
+			continue
+		}
+		found, exists := moviePath(resolver, movie)
+		switch {
+		case movie.Path != "":
+			stems[acquisitionStem(filepath.Base(movie.Movie))] = true
+		case frameMdoc:
+			// the mdoc of a tilt series stack without frames
+			if stacks[found] {
+				continue
+			}
+		default:
+			stems[strings.TrimSuffix(filepath.Base(movie.Source), filepath.Ext(movie.Source))] = true
+		}
+		report.MetadataEntries++
+		expected := resolver.relative(movie.Source)
+		if movie.Path != "" {
+			expected = movie.Path
+		}
+		if !exists {
+			report.MissingMovies = append(report.MissingMovies, expected)
+			continue
+		}
+		used[found] = true
+		size, known := sizes[found]
+		if !known {
+			if info, err := os.Stat(found); err == nil {
+				size = info.Size()
+			}
+		}
+		report.Paired++
+		report.MovieBytes += size
+		report.Pairs = append(report.Pairs, Pair{Metadata: resolver.relative(movie.Source), Movie: resolver.relative(found), Bytes: size})
+	}
+	report.MovieFiles = report.Paired
+	for path, size := range sizes {
+		if used[path] {
+			continue
+		}
+		if stems[acquisitionStem(filepath.Base(path))] {
+			continue
+		}
+		report.OrphanMovies = append(report.OrphanMovies, resolver.relative(path))
+		report.MovieFiles++
+		report.MovieBytes += size
+	}
+	sort.Strings(report.OrphanMovies)
+	sort.Strings(report.MissingMovies)
+	sort.Slice(report.Pairs, func(i, j int) bool { return report.Pairs[i].Metadata < report.Pairs[j].Metadata })
+	// nothing to pair, e.g. tilt series mdocs without SubFramePath, is nothing missing
+	report.Ratio = 1
+	if report.MetadataEntries > 0 {
+		report.Ratio = float64(report.Paired) / float64(report.MetadataEntries)
+	}
+	return report
+}
+
+// movieFilesBelow finds all movie files of the dataset with their size
+func movieFilesBelow(root string) map[string]int64 {
+	sizes := make(map[string]int64)
+	_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() && isHidden(entry.Name()) && path != root {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && isMovie(entry.Name()) {
+			if info, err := entry.Info(); err == nil {
+				sizes[path] = info.Size()
+			}
+		}
+		return nil
+	})
+	return sizes
+}
+
+// moviePath finds the movie file of a movie record: the SubFramePath of SerialEM, the movie a frame mdoc
+// is written next to or the EPU movie named like the xml. The sections of a tilt series mdoc have none.
+func moviePath(resolver *movieResolver, movie movieRecord) (string, bool) {
+	switch {
+	case movie.Path != "":
+		return resolver.resolve(movie.Path, movie.Source)
+	case strings.HasSuffix(movie.Source, ".mdoc") && isMovie(strings.TrimSuffix(movie.Source, ".mdoc")):
+		return resolver.resolve(filepath.Base(strings.TrimSuffix(movie.Source, ".mdoc")), movie.Source)
+	case strings.HasSuffix(movie.Source, ".mdoc"):
+		return "", false
+	}
+	return pairEPUMovie(resolver, strings.TrimSuffix(filepath.Base(movie.Source), filepath.Ext(movie.Source)))
+}
+
+// pairEPUMovie finds the movie of an EPU acquisition by its name
+func pairEPUMovie(resolver *movieResolver, stem string) (string, bool) {
+	for _, suffix := range movieSuffixes {
+		for _, extension := range movieExtensions {
+			// foo.mrc next to foo.xml is the averaged micrograph
+			if suffix == "" && extension == ".mrc" {
+				continue
+			}
+			if found, exists := resolver.index()[stem+suffix+extension]; exists {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (report *CompletenessReport) addTo(merged map[string]string) {
+	merged["CompletenessRatio"] = strconv.FormatFloat(report.Ratio, 'f', 4, 64)
+	merged["MoviesMissing"] = strconv.Itoa(len(report.MissingMovies))
+	merged["MoviesOrphaned"] = strconv.Itoa(len(report.OrphanMovies))
+	merged["MovieDataVolumeBytes"] = strconv.FormatInt(report.MovieBytes, 10)
+}
+
+func (report *CompletenessReport) summary() string {
+	return fmt.Sprintf("Completeness: %d of %d metadata entries have their movie (%.1f%%), %d movies without metadata, %d movie files with %d bytes",
+		report.Paired, report.MetadataEntries, report.Ratio*100, len(report.OrphanMovies), report.MovieFiles, report.MovieBytes)
+}
+
+func (report *CompletenessReport) writeJSON(path string) error {
+	content, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,92 @@
+package metadataparser
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCompleteness(t *testing.T) {
+	root := t.TempDir()
+	data := filepath.Join(root, "Images-Disc1", "GridSquare_1", "Data")
+	files := map[string]int{
+		filepath.Join(data, "FoilHole_1_Data_2_3.xml"):            10,
+		filepath.Join(data, "FoilHole_1_Data_2_3_fractions.tiff"): 1000,
+		filepath.Join(data, "FoilHole_1_Data_2_3.mrc"):            50,
+		filepath.Join(data, "FoilHole_4_Data_5_6.xml"):            10,
+		filepath.Join(data, "FoilHole_7_Data_8_9_EER.eer"):        700,
+		filepath.Join(root, "frames", "TS_1_001.tif"):             300,
+		filepath.Join(root, "TS_1.mrc"):                           80,
+		filepath.Join(root, "single.tif"):                         200,
+	}
+	for file, size := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mdoc := filepath.Join(root, "TS_1.mrc.mdoc")
+	tilt := newMovieRecord(mdoc)
+	tilt.setMdocValue("SubFramePath", `X:\raw\TS_1_001.tif`)
+	missingTilt := newMovieRecord(mdoc)
+	missingTilt.setMdocValue("SubFramePath", `X:\raw\TS_1_002.tif`)
+	movies := []movieRecord{
+		newMovieRecord(filepath.Join(data, "FoilHole_1_Data_2_3.xml")),
+		newMovieRecord(filepath.Join(data, "FoilHole_4_Data_5_6.xml")),
+		tilt,
+		missingTilt,
+		newMovieRecord(mdoc),
+		newMovieRecord(filepath.Join(root, "single.tif.mdoc")),
+	}
+
+	report := checkCompleteness(newMovieResolver(root, nil), movies, []imageFileReference{{referrer: mdoc, recorded: "TS_1.mrc"}})
+	assert.Equal(t, 5, report.MetadataEntries)
+	assert.Equal(t, 3, report.Paired)
+	assert.InDelta(t, 0.6, report.Ratio, 1e-9)
+	assert.Equal(t, []string{"Images-Disc1/GridSquare_1/Data/FoilHole_4_Data_5_6.xml", `X:\raw\TS_1_002.tif`}, report.MissingMovies)
+	assert.Equal(t, []string{"Images-Disc1/GridSquare_1/Data/FoilHole_7_Data_8_9_EER.eer"}, report.OrphanMovies)
+	assert.Equal(t, 4, report.MovieFiles)
+	assert.Equal(t, int64(1000+300+200+700), report.MovieBytes)
+	// pairs are given like the orphans, relative to the root
+	assert.Contains(t, report.Pairs, Pair{Metadata: "Images-Disc1/GridSquare_1/Data/FoilHole_1_Data_2_3.xml",
+		Movie: "Images-Disc1/GridSquare_1/Data/FoilHole_1_Data_2_3_fractions.tiff", Bytes: 1000})
+
+	// nothing to pair is complete
+	report = checkCompleteness(newMovieResolver(root, nil), []movieRecord{newMovieRecord(filepath.Join(root, "TS_2.st.mdoc"))}, nil)
+	assert.Equal(t, 0, report.MetadataEntries)
+	assert.Equal(t, 1.0, report.Ratio)
+}
+
+func TestCompletenessBatchFolder(t *testing.T) {
+	// the BatchPositionsList of EPU is no acquisition, it has no movie
+	root := t.TempDir()
+	data := filepath.Join(root, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "Batch"), 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(source)), content, 0644))
+		movie := strings.TrimSuffix(filepath.Base(source), ".xml") + "_EER.eer"
+		assert.NoError(t, os.WriteFile(filepath.Join(data, movie), []byte("eer"), 0644))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "Batch", "BatchPositionsList.xml"), []byte("<BatchPositionsList/>"), 0644))
+
+	result, err := Extract(root, Options{
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MinCompleteness: 0.9,
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "1.0000", result.merged["CompletenessRatio"])
+		assert.Equal(t, "0", result.merged["MoviesMissing"])
+	}
+}
@@ -0,0 +1,95 @@
+package metadataparser
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrParseFailures is returned in strict mode when more metadata files could not be read than allowed
+var ErrParseFailures = errors.New("metadata files could not be read")
+
+// Diagnostic is a metadata file that could not be read; Line and Offset are 0 if unknown
+type Diagnostic struct {
+	Path string `json:"path"`
+	// xml, mdoc or empty for files of an unknown type
+	Parser string `json:"parser,omitempty"`
+	Reason string `json:"reason"`
+	Line   int    `json:"line,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// parseError tells where a parser failed in a file
+type parseError struct {
+	parser string
+	line   int
+	offset int64
+	err    error
+}
+
+func (e *parseError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s line %d: %v", e.parser, e.line, e.err)
+	}
+	return fmt.Sprintf("%s: %v", e.parser, e.err)
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// xmlError adds the position of an xml syntax error, offset is where the decoder stopped
+func xmlError(err error, offset int64) error {
+	parsed := &parseError{parser: "xml", offset: offset, err: err}
+	var syntax *xml.SyntaxError
+	if errors.As(err, &syntax) {
+		parsed.line, parsed.err = syntax.Line, errors.New(syntax.Msg)
+	}
+	return parsed
+}
+
+// diagnose describes why path could not be read
+func diagnose(path string, err error) Diagnostic {
+	diagnostic := Diagnostic{Path: path, Reason: err.Error()}
+	var parsed *parseError
+	if errors.As(err, &parsed) {
+		diagnostic.Parser, diagnostic.Line, diagnostic.Offset, diagnostic.Reason = parsed.parser, parsed.line, parsed.offset, parsed.err.Error()
+		return diagnostic
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".xml", ".mdoc":
+		diagnostic.Parser = ext[1:]
+	}
+	return diagnostic
+}
+
+func (d Diagnostic) log(log *slog.Logger) {
+	attrs := []any{"path", d.Path, "parser", d.Parser, "reason", d.Reason}
+	if d.Line > 0 {
+		attrs = append(attrs, "line", d.Line)
+	}
+	if d.Offset > 0 {
+		attrs = append(attrs, "offset", d.Offset)
+	}
+	log.Warn("Metadata file could not be read", attrs...)
+}
+
+func sortDiagnostics(diagnostics []Diagnostic) {
+	sort.Slice(diagnostics, func(i, j int) bool { return diagnostics[i].Path < diagnostics[j].Path })
+}
+
+// checkFailures fails the run in strict mode if more than maxRatio of the files could not be read
+func checkFailures(diagnostics []Diagnostic, files int, strict bool, maxRatio float64) error {
+	if !strict || len(diagnostics) == 0 || files == 0 {
+		return nil
+	}
+	ratio := float64(len(diagnostics)) / float64(files)
+	if ratio > maxRatio {
+		return fmt.Errorf("%w: %d of %d files (%.3f > %.3f)", ErrParseFailures, len(diagnostics), files, ratio, maxRatio)
+	}
+	return nil
+}
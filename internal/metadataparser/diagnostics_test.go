@@ -0,0 +1,50 @@
+package metadataparser
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnostics(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	content, err := os.ReadFile(sources[0])
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(sources[0])), content, 0644))
+	broken := filepath.Join(data, filepath.Base(sources[1]))
+	assert.NoError(t, os.WriteFile(broken, []byte("<MicroscopeImage>\n<name>x</nam>\n</MicroscopeImage>"), 0644))
+	mdoc := filepath.Join(data, "empty.mdoc")
+	assert.NoError(t, os.Symlink(filepath.Join(data, "gone"), mdoc))
+
+	extract := func(strict bool, ratio float64) (*Result, error) {
+		return Extract(dataset, Options{
+			PathMappings:    []pathmap.Rule{},
+			WindowsPaths:    []pathmap.WindowsRule{},
+			Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Strict:          strict,
+			MaxFailureRatio: ratio,
+		})
+	}
+	result, err := extract(false, 0)
+	assert.NoError(t, err)
+	assert.Len(t, result.Diagnostics, 2)
+	assert.Equal(t, Diagnostic{Path: broken, Parser: "xml", Reason: "element <name> closed by </nam>", Line: 2, Offset: 31}, result.Diagnostics[0])
+	assert.Equal(t, mdoc, result.Diagnostics[1].Path)
+	assert.Equal(t, "mdoc", result.Diagnostics[1].Parser)
+
+	_, err = extract(true, 0)
+	assert.ErrorIs(t, err, ErrParseFailures)
+	// 2 of 3 files failed
+	_, err = extract(true, 0.5)
+	assert.ErrorIs(t, err, ErrParseFailures)
+	_, err = extract(true, 0.7)
+	assert.NoError(t, err)
+}
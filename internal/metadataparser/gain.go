@@ -0,0 +1,88 @@
+package metadataparser
+
+import (
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/gainref"
+)
+
+// gain reference orientation sources, besides the configuration
+const (
+	GainSourceOption  = "option"
+	GainSourceData    = "data"
+	GainSourceDefault = "default"
+)
+
+func sortedKeys(merged map[string]string) []string {
+	var keys []string
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// gainFromData derives the gain reference orientation from the RotationAndFlip that SerialEM records
+// for the frames it saved
+func gainFromData(merged map[string]string) (gainref.Transform, string, bool) {
+	for _, key := range sortedKeys(merged) {
+		if key == "RotationAndFlip" || strings.HasSuffix(key, ".RotationAndFlip") {
+			code, err := strconv.Atoi(strings.TrimSpace(merged[key]))
+			if err == nil && code >= 0 && code <= 7 {
+				return gainref.FromIMOD(code), key, true
+			}
+		}
+	}
+	return gainref.None, "", false
+}
+
+// gainFromEER assumes no transform if EPU wrote an EER gain reference for its Detectors[...], as EPU writes it
+// in the orientation of the EER movies. It is no recorded orientation, a configured one is taken over it.
+func gainFromEER(merged map[string]string) (gainref.Transform, string, bool) {
+	for _, key := range sortedKeys(merged) {
+		if strings.Contains(key, "Detectors[") && strings.HasSuffix(key, "].EerGainReference") && strings.TrimSpace(merged[key]) != "" {
+			return gainref.None, key, true
+		}
+	}
+	return gainref.None, "", false
+}
+
+// resolveGain picks the orientation of the gain reference: an explicit option over the RotationAndFlip of the
+// data over the default over the EER gain reference of EPU, and adds it with its notation in RELION, cryoSPARC and IMOD to the merged metadata. A value that is no known
+// transform, as the free-form values of older configs, is deprecated; it is passed on as it is with a warning.
+func resolveGain(merged map[string]string, explicit string, fallback string, log *slog.Logger) string {
+	given, source := explicit, GainSourceOption
+	if explicit == "" {
+		if derived, key, found := gainFromData(merged); found {
+			return addGain(merged, derived, GainSourceData+":"+key)
+		}
+		given, source = fallback, GainSourceDefault
+	}
+	if given == "" {
+		if derived, key, found := gainFromEER(merged); found {
+			return addGain(merged, derived, GainSourceData+":"+key)
+		}
+		return ""
+	}
+	transform, err := gainref.Parse(given)
+	if err != nil {
+		log.Warn("Deprecated gain reference orientation, it is passed on unchanged; use one of the names in the README", "value", given, "source", source, "error", err)
+		merged["GainReferenceTransform"] = given
+		merged["GainReferenceTransformSource"] = source
+		return given
+	}
+	return addGain(merged, transform, source)
+}
+
+func addGain(merged map[string]string, transform gainref.Transform, source string) string {
+	merged["GainReferenceTransform"] = transform.String()
+	merged["GainReferenceTransformSource"] = source
+	conventions := transform.Conventions()
+	merged["GainReferenceTransformRELION"] = conventions["relion"]
+	merged["GainReferenceTransformCryoSPARC"] = conventions["cryosparc"]
+	merged["GainReferenceTransformIMOD"] = conventions["imod"]
+	return transform.String()
+}
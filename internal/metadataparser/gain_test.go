@@ -0,0 +1,53 @@
+package metadataparser
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveGain(t *testing.T) {
+	eer := map[string]string{"Detectors[EF-Falcon].EerGainReference": "ImagesForProcessing/gain.gain"}
+	serialem := map[string]string{"RotationAndFlip": "5"}
+	tests := []struct {
+		name       string
+		merged     map[string]string
+		explicit   string
+		fallback   string
+		want       string
+		wantSource string
+		converted  bool
+	}{
+		{name: "explicit beats data", merged: eer, explicit: "rot90", fallback: "flipx", want: "rotate90", wantSource: GainSourceOption, converted: true},
+		{name: "eer gain from EPU", merged: eer, want: "none", wantSource: "data:Detectors[EF-Falcon].EerGainReference", converted: true},
+		// an EER gain reference is no recorded orientation, the config file is taken over it
+		{name: "default beats eer gain", merged: eer, fallback: "flipx", want: "flipx", wantSource: GainSourceDefault, converted: true},
+		{name: "SerialEM RotationAndFlip", merged: serialem, fallback: "flipx", want: "rotate270_flipx", wantSource: "data:RotationAndFlip", converted: true},
+		{name: "default", merged: map[string]string{}, fallback: "imod:4", want: "flipx", wantSource: GainSourceDefault, converted: true},
+		{name: "unknown", merged: map[string]string{}, want: ""},
+		// free-form values of older configs are passed on
+		{name: "deprecated", merged: map[string]string{}, explicit: "Flip Y then rotate", want: "Flip Y then rotate", wantSource: GainSourceOption},
+		{name: "deprecated default", merged: serialem, fallback: "sideways", want: "rotate270_flipx", wantSource: "data:RotationAndFlip", converted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := make(map[string]string)
+			for key, value := range tt.merged {
+				merged[key] = value
+			}
+			got := resolveGain(merged, tt.explicit, tt.fallback, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			assert.Equal(t, tt.want, got)
+			if tt.want != "" {
+				assert.Equal(t, tt.wantSource, merged["GainReferenceTransformSource"])
+				assert.Equal(t, tt.want, merged["GainReferenceTransform"])
+			}
+			if tt.converted {
+				assert.Contains(t, merged, "GainReferenceTransformRELION")
+			} else {
+				assert.NotContains(t, merged, "GainReferenceTransformRELION")
+			}
+		})
+	}
+}
@@ -1,12 +1,10 @@
 package metadataparser
 
 import (
-	"archive/zip"
-	"bufio"
-	"encoding/json"
-	"encoding/xml"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"math"
 	"os"
 	"path/filepath"
@@ -14,114 +12,61 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/osc-em/oscem-extractor-life/internal/configuration"
-
-	"golang.org/x/exp/mmap"
+	"github.com/osc-em/oscem-extractor-life/internal/autodoc"
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
 )
 
 // XML PART
-// Definitions of the xml structure
-type MicroscopeImage struct {
-	XMLName    xml.Name   `xml:"MicroscopeImage"`
-	Name       string     `xml:"name"`
-	UniqueID   string     `xml:"uniqueID"`
-	CustomData CustomData `xml:"CustomData"`
-}
-
-// for key-value
-type CustomData struct {
-	KeyValues []KeyValue `xml:"KeyValueOfstringanyType"`
-}
-
-type KeyValue struct {
-	Key   string `xml:"Key"`
-	Value string `xml:"Value"`
-}
-
-// For tag-value
-type Element struct {
-	XMLName  xml.Name
-	Content  string    `xml:",chardata"`
-	Children []Element `xml:",any"`
-}
-
-func parseElement(element Element, path string, leafNodes map[string]string) {
-	currentPath := path
-	if currentPath != "" {
-		currentPath += "." + element.XMLName.Local
-	} else {
-		currentPath = element.XMLName.Local
-	}
-
-	trimmedContent := strings.TrimSpace(element.Content)
-	if len(element.Children) == 0 && trimmedContent != "" {
-		leafNodes[currentPath] = trimmedContent
-	}
-	for _, child := range element.Children {
-		parseElement(child, currentPath, leafNodes)
-	}
-}
-
 func process_xml(input string) (map[string]string, error) {
 	// just here to catch some error messages would work just fine without
 	if strings.Contains(input, "BatchPositionsList") {
 		return nil, nil
 	}
-	reader, err := mmap.Open(input)
+	file, err := os.Open(input)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
-
-	xmlData := make([]byte, reader.Len())
-	_, err = reader.ReadAt(xmlData, 0)
+	defer file.Close()
+	doc, err := decodeXML(file)
 	if err != nil {
 		return nil, err
 	}
 
-	var root Element
-	err = xml.Unmarshal(xmlData, &root)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error unmarshaling XML:", err)
-		return nil, err
-	}
-
-	leafNodes := make(map[string]string)
-	parseElement(root, "", leafNodes)
-
-	var image MicroscopeImage
-	err = xml.Unmarshal(xmlData, &image)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error unmarshaling XML:", err)
-		return nil, err
-	}
-	leafNodes["MicroscopeImage.Name"] = image.Name
-	leafNodes["MicroscopeImage.UniqueID"] = image.UniqueID
-	for _, kv := range image.CustomData.KeyValues {
+	leafNodes := doc.leaves
+	leafNodes["MicroscopeImage.Name"] = doc.name
+	leafNodes["MicroscopeImage.UniqueID"] = doc.uniqueID
+	for _, kv := range doc.custom {
 		leafNodes[kv.Key] = kv.Value
+		// the i:type like the attributes of the other elements, left out of the merged metadata unless asked for
+		if kv.Type != "" {
+			leafNodes[kv.Key+".@type"] = kv.Type
+		}
 	}
-	return (leafNodes), err
+	return leafNodes, nil
 }
 func untuple(dict map[string]string, key string, match string) map[string]string {
+	pair := strings.Fields(match)
+	if len(pair) < 2 {
+		return dict
+	}
 	xcheck, xexist := dict[key+"_x_max"]
 	ycheck, yexist := dict[key+"_y_max"]
 	xcheck_min, xmexist := dict[key+"_x_min"]
 	ycheck_min, ymexist := dict[key+"_y_min"]
 	if !xexist && !yexist && !xmexist && !ymexist {
-		dict[key+"_x_max"] = strings.Split(match, " ")[0]
-		dict[key+"_y_max"] = strings.Split(match, " ")[1]
-		dict[key+"_x_min"] = strings.Split(match, " ")[0]
-		dict[key+"_y_min"] = strings.Split(match, " ")[1]
+		dict[key+"_x_max"] = pair[0]
+		dict[key+"_y_max"] = pair[1]
+		dict[key+"_x_min"] = pair[0]
+		dict[key+"_y_min"] = pair[1]
 	} else {
 		xtest_max, _ := strconv.ParseFloat(strings.TrimSpace(xcheck), 64)
 		ytest_max, _ := strconv.ParseFloat(strings.TrimSpace(ycheck), 64)
 		xtest_min, _ := strconv.ParseFloat(strings.TrimSpace(xcheck_min), 64)
 		ytest_min, _ := strconv.ParseFloat(strings.TrimSpace(ycheck_min), 64)
-		x_new, _ := strconv.ParseFloat(strings.TrimSpace(strings.Split(match, " ")[0]), 64)
-		y_new, _ := strconv.ParseFloat(strings.TrimSpace(strings.Split(match, " ")[1]), 64)
+		x_new, _ := strconv.ParseFloat(pair[0], 64)
+		y_new, _ := strconv.ParseFloat(pair[1], 64)
 		dict[key+"_x_max"] = strconv.FormatFloat(max(xtest_max, x_new), 'f', 16, 64)
 		dict[key+"_y_max"] = strconv.FormatFloat(max(ytest_max, y_new), 'f', 16, 64)
 		dict[key+"_x_min"] = strconv.FormatFloat(min(xtest_min, x_new), 'f', 16, 64)
@@ -131,111 +76,108 @@ func untuple(dict map[string]string, key string, match string) map[string]string
 }
 
 // MDOC Part
-func process_mdoc(input string) (map[string]string, error) {
+func process_mdoc(input string) (map[string]string, []movieRecord, error) {
 	var count float64 = 0.00
-	re := regexp.MustCompile(`(.+?)\s*=\s*(.+)`)
 	mdocFile, err := os.Open(input)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Your file didnt open", err)
-		return nil, err
+		return nil, nil, err
 	}
 	defer mdocFile.Close()
-	scanner := bufio.NewScanner(mdocFile)
+	doc, err := autodoc.Parse(mdocFile)
+	var readErr *autodoc.Error
+	if errors.As(err, &readErr) {
+		return nil, nil, &parseError{parser: "mdoc", line: readErr.Line, err: readErr.Err}
+	}
+	for _, skipped := range doc.Errors {
+		slog.Debug("Skipped mdoc line", "path", input, "line", skipped.Line, "error", skipped.Err)
+	}
 	mdoc_results := make(map[string]string)
+	var movies []movieRecord
 
-	for scanner.Scan() {
-		// Look for special case
-		//TiltAxis Angle
-		tiltaxis := strings.Contains(scanner.Text(), "TiltAxisAngle")    // Tomo 5
-		tiltaxis2 := strings.Contains(scanner.Text(), "Tilt axis angle") // SerialEM
-		if tiltaxis {
-			blabb_split := strings.Split(re.FindStringSubmatch(scanner.Text())[2], "=")[1]
-			mdoc_results["TiltAxisAngle"] = (strings.TrimSpace(strings.Split(blabb_split, "  ")[0])) // this is bound to fail at some point if they dont keep their weird double space seperation logic
-		}
-		if tiltaxis2 {
-			blab_split := strings.Split(re.FindStringSubmatch(scanner.Text())[2], ",")[0]
-			mdoc_results["TiltAxisAngle"] = (strings.TrimSpace(strings.Split(blab_split, "=")[1]))
-		}
-		// general search and update for min/max values
-		match := re.FindStringSubmatch(scanner.Text())
+	// general search and update for min/max values, key by key in the order of the file
+	observe := func(key string, value autodoc.Value) {
+		if value == "" {
+			return
+		}
 		//Detect which camera was used -- will only work with SerialEM properties update / script usage
-		cam := strings.Contains(scanner.Text(), "CameraIndex")
-		if cam {
-			if strings.TrimSpace(match[2]) == "0" {
+		if key == "CameraIndex" {
+			if value.String() == "0" {
 				mdoc_results["CameraUsed"] = mdoc_results["Camera0"]
-
-			} else if strings.TrimSpace(match[2]) == "1" {
+			} else if value.String() == "1" {
 				mdoc_results["CameraUsed"] = mdoc_results["Camera1"]
 			}
 		}
-
 		// Quick check incase the image dimesions are only present in the header
-		image := strings.Contains(scanner.Text(), "ImageSize")
-		if image {
-			mdoc_results["ImageDimensions_X"] = strings.Split(match[2], " ")[0]
-			mdoc_results["ImageDimensions_Y"] = strings.Split(match[2], " ")[1]
-		}
-		if match != nil {
-			if strings.TrimSpace(match[1]) == "[ZValue" {
-				count++
+		if size := value.Fields(); key == "ImageSize" && len(size) >= 2 {
+			mdoc_results["ImageDimensions_X"] = size[0]
+			mdoc_results["ImageDimensions_Y"] = size[1]
+		}
+		// Beamshift is only present in newer versions of SerialEM
+		tuple := key == "Beamshift" || key == "ImageShift" || key == "StagePosition"
+		previous, exists := mdoc_results[key]
+		if !exists {
+			mdoc_results[key] = string(value)
+			// grab the first occurence of a tuple as well
+			if _, isNumber := value.Float(); !isNumber && tuple && len(value.Fields()) > 1 {
+				mdoc_results = untuple(mdoc_results, key, string(value))
 			}
-			value, exists := mdoc_results[match[1]]
-			if !exists {
-				mdoc_results[match[1]] = match[2]
-				// grab the first occurence of a tuple as well
-				_, err := strconv.ParseFloat(strings.TrimSpace(match[2]), 64)
-				gate := len(strings.Split(match[2], " "))
-				if err != nil && gate > 1 {
-					beamshift := strings.Contains(scanner.Text(), "Beamshift") // check for correct syntax only present in newer versions of SerialEM
-					imageShift := strings.Contains(scanner.Text(), "ImageShift")
-					stagepos := strings.Contains(scanner.Text(), "StagePosition")
-					if beamshift || imageShift || stagepos {
-						mdoc_results = untuple(mdoc_results, match[1], match[2])
-					}
-					continue
-				}
-			} else if value == match[2] {
-				// Grab some Tuples
-				energy := strings.Contains(scanner.Text(), "FilterSlitAndLoss")
-				if energy {
-					energytest, _ := strconv.ParseFloat(strings.TrimSpace(strings.Split(match[2], " ")[0]), 64)
-					if energytest > float64(0.00) {
-						mdoc_results["EnergyFilterUsed"] = "true"
-						mdoc_results["EnergyFilterSlitWidth"] = strings.Split(match[2], " ")[0]
-					}
-				}
-				continue
-			} else if value != match[2] {
-				test, err := strconv.ParseFloat(strings.TrimSpace(mdoc_results[match[1]]), 64)
-				if err != nil {
-					// Grab the remaining Tuples
-					beamshift := strings.Contains(scanner.Text(), "Beamshift") // check for correct syntax only present in newer versions of SerialEM
-					imageShift := strings.Contains(scanner.Text(), "ImageShift")
-					stagepos := strings.Contains(scanner.Text(), "StagePosition")
-					if beamshift || imageShift || stagepos {
-						mdoc_results = untuple(mdoc_results, match[1], match[2])
-					}
-					continue
-				} else {
-					new, _ := strconv.ParseFloat(strings.TrimSpace(match[2]), 64)
-					keymin, existmin := mdoc_results[match[1]+"_min"]
-					keymax, existmax := mdoc_results[match[1]+"_max"]
-					if !existmin {
-						mdoc_results[match[1]+"_min"] = strconv.FormatFloat(min(test, new), 'f', 16, 64)
-					} else {
-						oldmin, _ := strconv.ParseFloat(strings.TrimSpace(keymin), 64)
-						mdoc_results[match[1]+"_min"] = strconv.FormatFloat(min(new, oldmin), 'f', 16, 64)
-					}
-					if !existmax {
-						mdoc_results[match[1]+"_max"] = strconv.FormatFloat(max(test, new), 'f', 16, 64)
-					} else {
-						oldmax, _ := strconv.ParseFloat(strings.TrimSpace(keymax), 64)
-						mdoc_results[match[1]+"_max"] = strconv.FormatFloat(max(new, oldmax), 'f', 16, 64)
-					}
+		} else if previous == string(value) {
+			// Grab some Tuples
+			if fields := value.Fields(); key == "FilterSlitAndLoss" && len(fields) > 0 {
+				if energytest, _ := strconv.ParseFloat(fields[0], 64); energytest > float64(0.00) {
+					mdoc_results["EnergyFilterUsed"] = "true"
+					mdoc_results["EnergyFilterSlitWidth"] = fields[0]
 				}
 			}
+		} else if test, isNumber := autodoc.Value(previous).Float(); !isNumber {
+			// Grab the remaining Tuples
+			if tuple {
+				mdoc_results = untuple(mdoc_results, key, string(value))
+			}
+		} else {
+			new, _ := value.Float()
+			keymin, existmin := mdoc_results[key+"_min"]
+			keymax, existmax := mdoc_results[key+"_max"]
+			if !existmin {
+				mdoc_results[key+"_min"] = strconv.FormatFloat(min(test, new), 'f', 16, 64)
+			} else {
+				oldmin, _ := strconv.ParseFloat(strings.TrimSpace(keymin), 64)
+				mdoc_results[key+"_min"] = strconv.FormatFloat(min(new, oldmin), 'f', 16, 64)
+			}
+			if !existmax {
+				mdoc_results[key+"_max"] = strconv.FormatFloat(max(test, new), 'f', 16, 64)
+			} else {
+				oldmax, _ := strconv.ParseFloat(strings.TrimSpace(keymax), 64)
+				mdoc_results[key+"_max"] = strconv.FormatFloat(max(new, oldmax), 'f', 16, 64)
+			}
+		}
+	}
+	for _, entry := range doc.Header {
+		observe(entry.Key, entry.Value)
+	}
+	for _, section := range doc.Sections {
+		//TiltAxis Angle, from the titles of Tomo 5 and SerialEM
+		if angle, ok := section.TiltAxisAngle(); ok {
+			mdoc_results["TiltAxisAngle"] = angle
+		}
+		// the section headers are kept as "[ZValue": "0]", "[T": "SerialEM: ...]"
+		observe("["+section.Type, section.Value+"]")
+		if section.Type == autodoc.TypeZValue {
+			count++
+		}
+		// every section is one movie/tilt, keep its own values for the timeline and reports
+		var movie *movieRecord
+		if section.Movie() {
+			movies = append(movies, newMovieRecord(input))
+			movie = &movies[len(movies)-1]
+			movie.TiltSeries = strings.TrimSuffix(filepath.Base(input), ".mdoc")
+		}
+		for _, entry := range section.Entries {
+			if movie != nil {
+				movie.setMdocValue(entry.Key, string(entry.Value))
+			}
+			observe(entry.Key, entry.Value)
 		}
-
 	}
 	// Numberoftilts
 	mdoc_results["NumberOfTilts"] = strconv.FormatFloat(count, 'f', 16, 64)
@@ -245,11 +187,11 @@ func process_mdoc(input string) (map[string]string, error) {
 	if existtilt && count != 0.00 {
 		tiltmax, err := strconv.ParseFloat(strings.TrimSpace(mdoc_results["TiltAngle_max"]), 64)
 		if err != nil {
-			fmt.Println("Tilt angle increment calculation failed")
+			slog.Warn("Tilt angle increment calculation failed", "path", input, "error", err)
 		}
 		tiltmin, err := strconv.ParseFloat(strings.TrimSpace(mdoc_results["TiltAngle_min"]), 64)
 		if err != nil {
-			fmt.Println("Tilt angle increment calculation failed")
+			slog.Warn("Tilt angle increment calculation failed", "path", input, "error", err)
 		}
 		mdoc_results["Tilt_increment"] = strconv.FormatFloat(math.Abs(tiltmax-tiltmin)/count, 'f', 16, 64)
 	}
@@ -307,90 +249,116 @@ func process_mdoc(input string) (map[string]string, error) {
 			delete(mdoc_results, key)
 		}
 	}
-	return mdoc_results, err
+	return mdoc_results, movies, nil
+}
+
+var timeformats = []string{
+	"02-Jan-06  15:04:05",
+	"02-Jan-2006  15:04:05",
+	"2006-Jan-02  15:04:05",
+	time.RFC3339Nano,
 }
 
 // MERGE and datetimechecks
+// datasetMerger folds the per file maps into the dataset level one file at a time: values that agree are
+// kept, differing numbers become _min/_max and differing timestamps _start/_end
+type datasetMerger struct {
+	overallmap map[string]string
+	dose_avg   float64
+	count      int
+}
+
 func merge_to_dataset_level(listofcontents []map[string]string) map[string]string {
-	overallmap := make(map[string]string)
-	timeformats := []string{
-		"02-Jan-06  15:04:05",
-		"02-Jan-2006  15:04:05",
-		"2006-Jan-02  15:04:05",
-		time.RFC3339Nano,
-	}
-	dose_avg := 0.0
+	var merger datasetMerger
 	for item := range listofcontents {
-		for key := range listofcontents[item] {
-			value, exists := overallmap[key]
-			valuenew := (listofcontents[item])[key]
-			// get dose average
-			if strings.Contains(key, "DoseOnCamera") || strings.Contains(key, "ExposureDose") {
-				convtest, err := strconv.ParseFloat(strings.TrimSpace(valuenew), 64)
-				if err == nil {
-					dose_avg += convtest
-				}
+		merger.add(listofcontents[item])
+	}
+	return merger.result()
+}
+
+func (merger *datasetMerger) add(content map[string]string) {
+	if merger.overallmap == nil {
+		merger.overallmap = make(map[string]string)
+	}
+	overallmap := merger.overallmap
+	merger.count++
+	for key := range content {
+		value, exists := overallmap[key]
+		valuenew := content[key]
+		// get dose average
+		if strings.Contains(key, "DoseOnCamera") || strings.Contains(key, "ExposureDose") {
+			convtest, err := strconv.ParseFloat(strings.TrimSpace(valuenew), 64)
+			if err == nil {
+				merger.dose_avg += convtest
 			}
-			if !exists {
-				overallmap[key] = valuenew
-			} else if value == valuenew {
-				continue
-			} else if value != valuenew {
-				if strings.Contains(key, "DateTime") {
-					for _, datetime := range timeformats {
-						timecheck, err1 := time.Parse(datetime, value)
-						timechecknew, err := time.Parse(datetime, valuenew)
-						if err == nil && err1 == nil {
-							_, existstart := overallmap[key+"_start"]
-							_, existend := overallmap[key+"_end"]
-							if !existstart {
-								if timecheck.After(timechecknew) {
-									overallmap[key+"_start"] = timechecknew.Format(time.RFC3339)
-								} else {
-									overallmap[key+"_start"] = timecheck.Format(time.RFC3339)
-								}
+		}
+		if !exists {
+			overallmap[key] = valuenew
+		} else if value == valuenew {
+			continue
+		} else if value != valuenew {
+			if strings.Contains(key, "DateTime") {
+				for _, datetime := range timeformats {
+					timecheck, err1 := time.Parse(datetime, value)
+					timechecknew, err := time.Parse(datetime, valuenew)
+					if err == nil && err1 == nil {
+						_, existstart := overallmap[key+"_start"]
+						_, existend := overallmap[key+"_end"]
+						if !existstart {
+							if timecheck.After(timechecknew) {
+								overallmap[key+"_start"] = timechecknew.Format(time.RFC3339)
 							} else {
-								timecheckold, _ := time.Parse(time.RFC3339, overallmap[key+"_start"])
-								if timecheckold.After(timechecknew) {
-									overallmap[key+"_start"] = timechecknew.Format(time.RFC3339)
-								}
+								overallmap[key+"_start"] = timecheck.Format(time.RFC3339)
 							}
-							if !existend {
-								if timecheck.Before(timechecknew) {
-									overallmap[key+"_end"] = timechecknew.Format(time.RFC3339)
-								} else {
-									overallmap[key+"_end"] = timecheck.Format(time.RFC3339)
-								}
+						} else {
+							timecheckold, _ := time.Parse(time.RFC3339, overallmap[key+"_start"])
+							if timecheckold.After(timechecknew) {
+								overallmap[key+"_start"] = timechecknew.Format(time.RFC3339)
+							}
+						}
+						if !existend {
+							if timecheck.Before(timechecknew) {
+								overallmap[key+"_end"] = timechecknew.Format(time.RFC3339)
 							} else {
-								timecheckold, _ := time.Parse(time.RFC3339, overallmap[key+"_end"])
-								if timecheckold.Before(timechecknew) {
-									overallmap[key+"_end"] = timechecknew.Format(time.RFC3339)
-								}
+								overallmap[key+"_end"] = timecheck.Format(time.RFC3339)
+							}
+						} else {
+							timecheckold, _ := time.Parse(time.RFC3339, overallmap[key+"_end"])
+							if timecheckold.Before(timechecknew) {
+								overallmap[key+"_end"] = timechecknew.Format(time.RFC3339)
 							}
 						}
 					}
 				}
-				test, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
-				if err == nil {
-					new, _ := strconv.ParseFloat(strings.TrimSpace(valuenew), 64)
-					keymin, existmin := overallmap[key+"_min"]
-					keymax, existmax := overallmap[key+"_max"]
-					if !existmin {
-						overallmap[key+"_min"] = strconv.FormatFloat(min(test, new), 'f', 16, 64)
-					} else {
-						oldmin, _ := strconv.ParseFloat(strings.TrimSpace(keymin), 64)
-						overallmap[key+"_min"] = strconv.FormatFloat(min(new, oldmin), 'f', 16, 64)
-					}
-					if !existmax {
-						overallmap[key+"_max"] = strconv.FormatFloat(max(test, new), 'f', 16, 64)
-					} else {
-						oldmax, _ := strconv.ParseFloat(strings.TrimSpace(keymax), 64)
-						overallmap[key+"_max"] = strconv.FormatFloat(max(new, oldmax), 'f', 16, 64)
-					}
+			}
+			test, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil {
+				new, _ := strconv.ParseFloat(strings.TrimSpace(valuenew), 64)
+				keymin, existmin := overallmap[key+"_min"]
+				keymax, existmax := overallmap[key+"_max"]
+				if !existmin {
+					overallmap[key+"_min"] = strconv.FormatFloat(min(test, new), 'f', 16, 64)
+				} else {
+					oldmin, _ := strconv.ParseFloat(strings.TrimSpace(keymin), 64)
+					overallmap[key+"_min"] = strconv.FormatFloat(min(new, oldmin), 'f', 16, 64)
+				}
+				if !existmax {
+					overallmap[key+"_max"] = strconv.FormatFloat(max(test, new), 'f', 16, 64)
+				} else {
+					oldmax, _ := strconv.ParseFloat(strings.TrimSpace(keymax), 64)
+					overallmap[key+"_max"] = strconv.FormatFloat(max(new, oldmax), 'f', 16, 64)
 				}
 			}
 		}
 	}
+}
+
+// result is the dataset level map of the files added so far, the merger can take more files afterwards
+func (merger *datasetMerger) result() map[string]string {
+	overallmap := make(map[string]string, len(merger.overallmap)+2)
+	for key, value := range merger.overallmap {
+		overallmap[key] = value
+	}
 	for key := range overallmap {
 		_, upexist := overallmap[key+"_max"]
 		_, dwnexist := overallmap[key+"_min"]
@@ -400,82 +368,66 @@ func merge_to_dataset_level(listofcontents []map[string]string) map[string]strin
 			delete(overallmap, key)
 		}
 	}
-	overallmap["NumberOfMovies"] = strconv.Itoa(len(listofcontents))
-	overallmap["DoseAverage"] = strconv.FormatFloat(dose_avg/float64(len(listofcontents)), 'f', 16, 64)
+	overallmap["NumberOfMovies"] = strconv.Itoa(merger.count)
+	overallmap["DoseAverage"] = strconv.FormatFloat(merger.dose_avg/float64(merger.count), 'f', 16, 64)
 	return overallmap
 }
 
-type xmlResult struct {
-	filePath string
-	content  map[string]string
-}
-
-type mdocResult struct {
-	content map[string]string
-}
-
-func readin(jobs <-chan string, results chan<- interface{}, wg *sync.WaitGroup, progresstracker *ProgressTracker) {
+func readin(ctx context.Context, jobs <-chan string, outcomes chan<- parseOutcome, wg *sync.WaitGroup, progress *progressReporter, parse func(string) (parsedFile, error)) {
 	defer wg.Done()
 	for filePath := range jobs {
-		switch filepath.Ext(filePath) {
-		case ".xml":
-			xmlContent, err := process_xml(filePath)
-			if err == nil {
-				results <- xmlResult{filePath: filePath, content: xmlContent}
-			} else {
-				fmt.Fprintln(os.Stderr, "Import of", filePath, "failed")
-			}
-		case ".mdoc":
-			mdocContent, err := process_mdoc(filePath)
-			if err == nil {
-				results <- mdocResult{content: mdocContent}
-			} else {
-				fmt.Fprintln(os.Stderr, "Import of", filePath, "failed")
-			}
-		default:
-			fmt.Fprintf(os.Stderr, "Unknown file type: %s\n", filePath)
+		if ctx.Err() != nil {
+			return
 		}
-		atomic.AddInt64(&progresstracker.Completed, 1)
-	}
-}
-
-func zipFiles(files []string) error {
-	archive, err := os.Create("xmls.zip")
-	if err != nil {
-		return err
-	}
-	defer archive.Close()
-	writer := zip.NewWriter(archive)
-	defer writer.Close()
-	for _, file := range files {
-		err = addFileToZip(writer, file)
-		if err != nil {
-			return err
+		parsed, err := parse(filePath)
+		if ctx.Err() != nil {
+			// cancelled while reading, which is not the fault of the file
+			return
+		}
+		progress.read(filePath, err)
+		if err == nil {
+			outcomes <- parseOutcome{file: parsed}
+		} else {
+			failure := diagnose(filePath, err)
+			outcomes <- parseOutcome{failure: &failure}
 		}
 	}
-	return nil
 }
 
-func addFileToZip(writer *zip.Writer, file string) error {
-	op, err := os.Open(file)
-	if err != nil {
-		return err
-	}
-	defer op.Close()
-	test := strings.Split(file, string(filepath.Separator))
-	name := test[len(test)-1]
-	wr, err := writer.Create(name)
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(wr, op)
-	if err != nil {
-		return err
+var errFileTimeout = errors.New("reading the file timed out")
+
+// parseContext parses the file in the background and gives up once ctx is done or timeout passed. A read
+// hanging on a stalled mount can not be interrupted, its goroutine ends whenever the read returns.
+func parseContext(ctx context.Context, path string, timeout time.Duration, parse func(string) (parsedFile, error)) (parsedFile, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errFileTimeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return parse(path)
+	}
+	type outcome struct {
+		parsed parsedFile
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		parsed, err := parse(path)
+		done <- outcome{parsed, err}
+	}()
+	select {
+	case result := <-done:
+		return result.parsed, result.err
+	case <-ctx.Done():
+		if errors.Is(context.Cause(ctx), errFileTimeout) {
+			return parsedFile{}, fmt.Errorf("%w after %s", errFileTimeout, timeout)
+		}
+		return parsedFile{}, ctx.Err()
 	}
-	return nil
 }
 
-func findDataFolders(inputDir string, dataFolders []string, folderFlag string) ([]string, error) {
+func findDataFolders(ctx context.Context, inputDir string, dataFolders []string, folderFlag string) ([]string, error) {
 
 	foldersRegex := "Data|Batch"
 	if folderFlag != "" {
@@ -486,6 +438,9 @@ func findDataFolders(inputDir string, dataFolders []string, folderFlag string) (
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !info.IsDir() {
 			return nil
 		}
@@ -500,33 +455,73 @@ func findDataFolders(inputDir string, dataFolders []string, folderFlag string) (
 	return dataFolders, err
 }
 
+// SampleMetadata returns the metadata of the first readable xml/mdoc of a dataset, searched in the
+// dataset itself and then in every existing EPU mirror the rules map it to. Used to tell the instrument
+// before the extraction.
+func SampleMetadata(topLevelDirectory string, rules []pathmap.Rule, metadataFolderRegex string) (map[string]string, error) {
+	foldersRegex := "Data|Batch"
+	if metadataFolderRegex != "" {
+		foldersRegex = foldersRegex + "|" + metadataFolderRegex
+	}
+	foldersRegexCompiled, err := regexp.Compile(foldersRegex)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []string{topLevelDirectory}
+	mirrors, err := pathmap.Candidates(rules, topLevelDirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, mirror := range mirrors {
+		if mirror.Exists {
+			candidates = append(candidates, mirror.Path)
+		}
+	}
+	errFound := errors.New("found")
+	var sample map[string]string
+	for _, candidate := range candidates {
+		_ = filepath.WalkDir(candidate, func(path string, entry os.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || isHidden(entry.Name()) {
+				return nil
+			}
+			folder := filepath.Dir(path)
+			if folder != filepath.Clean(candidate) && !foldersRegexCompiled.MatchString(filepath.Base(folder)) {
+				return nil
+			}
+			var content map[string]string
+			var parseErr error
+			switch filepath.Ext(path) {
+			case ".xml":
+				content, parseErr = process_xml(path)
+			case ".mdoc":
+				content, _, parseErr = process_mdoc(path)
+			default:
+				return nil
+			}
+			if parseErr != nil || len(content) == 0 {
+				return nil
+			}
+			sample = content
+			return errFound
+		})
+		if sample != nil {
+			return sample, nil
+		}
+	}
+	return nil, errNothingRead
+}
+
 // minicheck against hidden files
 func isHidden(name string) bool {
 	return len(name) > 0 && name[0] == '.'
 }
 
-type ProgressTracker struct {
-	Total     int64
-	Completed int64
-}
-
-func startProgressReporter(progressTracker *ProgressTracker) {
-	for {
-		completed := atomic.LoadInt64(&progressTracker.Completed)
-		total := atomic.LoadInt64(&progressTracker.Total)
-		progress := float64(completed) / float64(total) * 100
-		fmt.Printf("\rProgress: %.2f%%", progress)
-		if completed >= total {
-			fmt.Println("")
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-}
-
-func collectAllFiles(directories []string) ([]string, error) {
+func collectAllFiles(ctx context.Context, directories []string) ([]string, error) {
 	var allFiles []string
 	for _, dir := range directories {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		files, err := os.ReadDir(dir)
 		if err != nil {
 			return nil, err
@@ -540,133 +535,158 @@ func collectAllFiles(directories []string) ([]string, error) {
 	return allFiles, nil
 }
 
-func ReadMetadata(topLevelDirectory string, create_zip bool, write_full_metadata bool, epu_folder string, metadataFolderRegex string) ([]byte, error) {
-
-	// Check if the provided directory exists
-	fileInfo, err := os.Stat(topLevelDirectory)
-	if os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: Directory '%s' does not exist.\n", topLevelDirectory)
-		return nil, err
-	}
-
-	// Check if the provided path is a directory
-	if !fileInfo.IsDir() {
-		fmt.Fprintf(os.Stderr, "Error: '%s' is not a directory.\n", topLevelDirectory)
-		return nil, err
-	}
-	// this part is to make sure there is no confusion on the instrument computer search when running on the Athena server folder with "./"
-	directory_safe, _ := filepath.Abs(topLevelDirectory)
-	correct := strings.Split(directory_safe, string(filepath.Separator))
-	target := correct[len(correct)-1]
-	var dataFolders []string
-	dataFolders, err = findDataFolders(topLevelDirectory, dataFolders, metadataFolderRegex)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Folder search failed - is this the correct directory?", err)
-		return nil, err
-	}
-	var parallel string
-	if epu_folder == "" {
-		var getmpc map[string]string
-		config, err := configuration.Getconfig()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Warning: No path config available, we suggest using either --epu or the config to provide the path where EPU mirrors the datasets and stores xmls")
-		} else {
-			errun := json.Unmarshal(config, &getmpc)
-			if errun != nil {
-				fmt.Fprintln(os.Stderr, "Your config was unretrievable, make sure it is set and accessible or use the param flags")
-			}
-			parallel = getmpc["MPCPATH"]
-		}
-	} else {
-		parallel = epu_folder
-	}
-
-	if parallel != "" {
-		dataFolders, err = findDataFolders(parallel+target, dataFolders, metadataFolderRegex)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "There should be a folder on your instrument control computer with the same name - something went wrong here", err)
-			return nil, err
-		}
-	}
-	dataFolders = append(dataFolders, topLevelDirectory)
-	progress := &ProgressTracker{}
+// Options holds the settings of an extraction run, ReadMetadata covers the common subset.
+type Options struct {
+	// archive the metadata and session files, to ZipOut or xmls.zip (xmls.tar.gz) in the working directory
+	CreateZip bool
+	// where to write the archive, implies CreateZip
+	ZipOut string
+	// archive.FormatZip or archive.FormatTarGz, empty to take it from the extension of ZipOut
+	ArchiveFormat string
+	// 1 (fastest) to 9 (smallest), 0 for the default
+	CompressionLevel  int
+	WriteFullMetadata bool
+	// where to write the full metadata, implies WriteFullMetadata; Stdout for the standard output,
+	// empty for <dataset folder>_full.json in the working directory
+	FullMetadataOut string
+	// overwrite an existing full metadata file
+	Force bool
+	// keep the i:type of the EPU CustomData entries as <key>.@type in the metadata
+	XMLTypes bool
+	// the MPCPATH, where EPU mirrors the datasets; the library reads no config, the caller resolves it
+	EPUFolder           string
+	MetadataFolderRegex string
+	// counts the files read while the extraction runs, nil if not needed
+	Progress *ProgressTracker
+	// called with every progress event, one at a time; nothing is printed for the progress
+	OnProgress func(ProgressEvent)
+	// number of files read in parallel, defaults to GOMAXPROCS
+	Workers int
+	// a file taking longer to read (e.g. on a stalled network mount) is given up and reported, 0 for no limit
+	FileTimeout time.Duration
+	// where to log to, nil for slog.Default()
+	Logger *slog.Logger
+	// return ErrParseFailures instead of the metadata if more than MaxFailureRatio of the files could not be read
+	Strict          bool
+	MaxFailureRatio float64
+	// keep the parsed files between runs, so only new and changed files are parsed again
+	Cache bool
+	// where to keep the cache, implies Cache; empty for DefaultCacheDir
+	CacheDir string
+	// where to write the session timeline as csv, empty to skip
+	TimelineCSV string
+	// pauses between movies longer than this are reported as gaps, defaults to 10 minutes
+	GapThreshold time.Duration
+	// json file with additional quality rules, see LoadQualityRules
+	QualityRules string
+	// where to write the quality report, empty to skip
+	QualityReport string
+	// return ErrQualityErrors instead of the metadata if any error rule matched
+	FailOnQualityErrors bool
+	// cluster the beam-image shifts into optics groups, OpticsGroupsGrid or OpticsGroupsKMeans, empty to skip
+	OpticsGroups string
+	// number of groups for kmeans, 0 to take the number the grid method finds
+	OpticsGroupsK int
+	// maximal distance of a shift to its group centre for the grid method, 0 for automatic
+	OpticsGroupsRadius float64
+	// rules mapping the dataset to its EPU mirror, tried before EPUFolder
+	PathMappings []pathmap.Rule
+	// print the mirror candidates, the metadata folders found in the chosen one and the missing movies to stderr
+	ExplainPaths bool
+	// rules translating the movie paths of the acquisition computer
+	WindowsPaths []pathmap.WindowsRule
+	// where to write the report pairing metadata and movies, empty to skip
+	CompletenessReport string
+	// return ErrIncomplete instead of the metadata if fewer metadata entries have their movie, 0 to skip
+	MinCompleteness float64
+	// where to write the checksums of the metadata and movie files, empty to skip
+	Manifest string
+	// manifest.FormatCSV (default), manifest.FormatBagIt or manifest.FormatSciCat
+	ManifestFormat string
+	// manifest.SHA256 (default) or manifest.XXH64
+	ManifestChecksum string
+	// number of files checksummed in parallel, defaults to 4
+	ManifestWorkers int
+	// gain reference orientation (see gainref.Parse) used instead of the one derived from the data
+	GainFlipRotate string
+	// gain reference orientation used if none is given and none can be derived from the data
+	GainFlipRotateDefault string
+}
 
-	allfiles, err := collectAllFiles(dataFolders)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not collect files:", dataFolders, err)
+func (opts Options) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.Default()
 	}
-	progress.Total = int64(len(allfiles))
-	fmt.Printf("Total number of files to process: %d\n", progress.Total)
-	go startProgressReporter(progress)
+	return opts.Logger
+}
 
-	jobs := make(chan string, len(allfiles))
-	for _, filePath := range allfiles {
-		jobs <- filePath
-	}
-	close(jobs)
+func ReadMetadata(topLevelDirectory string, create_zip bool, write_full_metadata bool, epu_folder string, metadataFolderRegex string) ([]byte, error) {
+	return ReadMetadataWithOptions(topLevelDirectory, Options{
+		CreateZip:           create_zip,
+		WriteFullMetadata:   write_full_metadata,
+		EPUFolder:           epu_folder,
+		MetadataFolderRegex: metadataFolderRegex,
+	})
+}
 
-	var mdoc_files []map[string]string
-	var xml_files []map[string]string
-	var listxml []string
+var errNothingRead = errors.New("no xml or mdoc metadata could be read")
+
+// Result is everything an extraction run collected, Metadata is the dataset level json returned by ReadMetadata.
+type Result struct {
+	Metadata []byte
+	Quality  *QualityReport
+	// canonical gain reference orientation, empty if unknown
+	GainFlipRotate string
+	// pairing of metadata and movies, nil unless requested
+	Completeness *CompletenessReport
+	// the metadata files that could not be read, by path
+	Diagnostics []Diagnostic
+	// the extraction was cancelled while reading the files, the metadata covers only those read so far
+	Incomplete bool
+	name       string
+	merged     map[string]string
+	movies     []movieRecord
+	timeline   timeline
+	timed      bool
+	optics     *opticsGroups
+}
 
-	var wg sync.WaitGroup
-	numWorkers := 16
-	results := make(chan interface{}, len(allfiles))
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go readin(jobs, results, &wg, progress)
+func ReadMetadataWithOptions(topLevelDirectory string, opts Options) ([]byte, error) {
+	result, err := Extract(topLevelDirectory, opts)
+	if err != nil || result == nil {
+		return nil, err
 	}
+	return result.Metadata, nil
+}
 
-	wg.Wait()
-	close(results)
-	for result := range results {
-		switch res := result.(type) {
-		case xmlResult:
-			xml_files = append(xml_files, res.content)
-			listxml = append(listxml, res.filePath)
-		case mdocResult:
-			mdoc_files = append(mdoc_files, res.content)
-		}
-	}
-	// whether to generate zip of xmls
-	if create_zip && listxml != nil {
-		zipFiles(listxml)
-	}
+func Extract(topLevelDirectory string, opts Options) (*Result, error) {
+	return ExtractContext(context.Background(), topLevelDirectory, opts)
+}
 
-	var out map[string]string
-	if mdoc_files != nil && xml_files == nil {
-		out = merge_to_dataset_level(mdoc_files)
-	} else if xml_files != nil && mdoc_files == nil {
-		out = merge_to_dataset_level(xml_files)
-	} else if xml_files != nil && mdoc_files != nil {
-		out = merge_to_dataset_level(xml_files)
-		b := merge_to_dataset_level(mdoc_files)
-		for x, y := range b {
-			out[x] = y
-		}
-	} else {
-		fmt.Println("Something went wrong, nothing was read out")
+// ExtractContext is Extract stopping once ctx is done. If that happens while the files are read, the files
+// read so far are finished into a Result marked Incomplete, which is returned together with the error.
+func ExtractContext(ctx context.Context, topLevelDirectory string, opts Options) (*Result, error) {
+	s, err := newSession(topLevelDirectory, opts)
+	if err != nil {
 		return nil, err
 	}
-
-	jsonData, err := json.MarshalIndent(out, "", "    ")
+	allfiles, err := s.discover(ctx)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error marshaling to JSON:", err)
 		return nil, err
 	}
-	var nameout string
-	if len(correct) > 0 {
-		nameout = target + "_full.json"
-	} else {
-		fmt.Fprintln(os.Stderr, "Name generation failed, returning to default")
-		nameout = "Dataset_out.json"
-	}
-	if write_full_metadata {
-		err = os.WriteFile(nameout, jsonData, 0644)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error writing JSON to file:", err)
-		}
-		fmt.Println("Extracted full data has been written to ", nameout)
+	s.progress.discovered(len(allfiles))
+	c := newCollection(s.qualityRules)
+	c.failed = s.parse(ctx, allfiles, c.add)
+	s.saveCache(allfiles)
+	if ctx.Err() == nil {
+		return s.finish(allfiles, c)
+	}
+	c.incomplete = true
+	read := len(c.paths) + len(c.failed)
+	s.log.Warn("The extraction was interrupted, finishing the files read so far", "read", read, "files", len(allfiles))
+	result, err := s.finish(allfiles, c)
+	if err != nil {
+		return nil, err
 	}
-	return jsonData, nil
+	return result, fmt.Errorf("extraction interrupted after %d of %d files: %w", read, len(allfiles), ctx.Err())
 }
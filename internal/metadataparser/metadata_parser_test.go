@@ -3,6 +3,7 @@ package metadataparser
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 
 	conversion "github.com/osc-em/oscem-converter-extracted"
@@ -213,3 +214,32 @@ func filterMap(input map[string]string, excludeKeys []string) map[string]string
 func preprocessMap(input map[string]string, excludeKeys []string) map[string]string {
 	return filterMap(input, excludeKeys)
 }
+
+func TestProcessMdocMalformed(t *testing.T) {
+	// lines the former line matching panicked on or gave to the wrong movie
+	mdoc := filepath.Join(t.TempDir(), "TS_1.mrc.mdoc")
+	content := "ImageSize = 4096\nTiltAxisAngle = 85.1\nImageShift = 1.5\n[T = TOMOGRAPHY  TiltAxisAngle = -95.2  Binning = 1]\n" +
+		"no equals sign\n[ZValue = 0]\nTiltAngle = -60\nImageShift = 0.5 0.25\nDefocus = -2\nImageShift = 1\nFilterSlitAndLoss =\n" +
+		"[MontSection = 0]\nDefocus = -9\n[ZValue = 1]\nTiltAngle = 60\nDefocus = -3\n"
+	assert.NoError(t, os.WriteFile(mdoc, []byte(content), 0644))
+	results, movies, err := process_mdoc(mdoc)
+	assert.NoError(t, err)
+	assert.Equal(t, "-95.2", results["TiltAxisAngle"])
+	assert.Equal(t, "2.0000000000000000", results["NumberOfTilts"])
+	assert.NotContains(t, results, "ImageDimensions_X")
+	assert.Equal(t, "-9.0000000000000000", results["Defocus_min"])
+	if assert.Len(t, movies, 2) {
+		assert.Equal(t, -2.0, movies[0].Defocus)
+		assert.Equal(t, -3.0, movies[1].Defocus)
+	}
+
+	// an empty slit width repeated, with Windows line endings converted twice or other whitespace
+	content = "[ZValue = 0]\r\r\nFilterSlitAndLoss =\r\r\nDefocus = -2\r\r\n[ZValue = 1]\r\r\nFilterSlitAndLoss =\r\r\nDefocus = -3\r\r\n" +
+		"[ZValue = 2]\nFilterSlitAndLoss = \v\nDefocus = -1\n[ZValue = 3]\nFilterSlitAndLoss = \v\n"
+	assert.NoError(t, os.WriteFile(mdoc, []byte(content), 0644))
+	results, movies, err = process_mdoc(mdoc)
+	assert.NoError(t, err)
+	assert.NotContains(t, results, "EnergyFilterUsed")
+	assert.Equal(t, "-3.0000000000000000", results["Defocus_min"])
+	assert.Len(t, movies, 4)
+}
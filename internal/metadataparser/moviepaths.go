@@ -0,0 +1,156 @@
+package metadataparser
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+)
+
+// movieResolver finds the files the metadata refers to (SubFramePath, ImageFile), which are
+// recorded as seen from the acquisition computer
+type movieResolver struct {
+	root   string
+	rules  []pathmap.WindowsRule
+	byName map[string]string
+}
+
+func newMovieResolver(root string, rules []pathmap.WindowsRule) *movieResolver {
+	return &movieResolver{root: root, rules: rules}
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// resolve returns where the recorded file is: translated by the windows rules, as given,
+// next to the referring metadata file or anywhere below the dataset root with the same name.
+// If it is missing, the translated path (if any) is returned for diagnostics.
+func (resolver *movieResolver) resolve(recorded string, referrer string) (string, bool) {
+	recorded = strings.TrimSpace(recorded)
+	if recorded == "" {
+		return "", false
+	}
+	var tried string
+	if pathmap.IsWindows(recorded) {
+		if translated, ok := pathmap.ToPOSIX(recorded, resolver.rules); ok {
+			if fileExists(translated) {
+				return translated, true
+			}
+			tried = translated
+		}
+	} else if filepath.IsAbs(recorded) {
+		if fileExists(recorded) {
+			return recorded, true
+		}
+		tried = recorded
+	} else if beside := filepath.Join(filepath.Dir(referrer), recorded); fileExists(beside) {
+		return beside, true
+	}
+	if found, exists := resolver.index()[pathmap.Base(recorded)]; exists {
+		return found, true
+	}
+	return tried, false
+}
+
+// index of the files below the dataset root by name, built on first use
+func (resolver *movieResolver) index() map[string]string {
+	if resolver.byName != nil {
+		return resolver.byName
+	}
+	resolver.byName = make(map[string]string)
+	_ = filepath.WalkDir(resolver.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() && isHidden(entry.Name()) && path != resolver.root {
+			return filepath.SkipDir
+		}
+		if _, seen := resolver.byName[entry.Name()]; !seen && !entry.IsDir() {
+			resolver.byName[entry.Name()] = path
+		}
+		return nil
+	})
+	return resolver.byName
+}
+
+// relative is the path below the dataset root, other paths stay absolute
+func (resolver *movieResolver) relative(path string) string {
+	relative, err := filepath.Rel(resolver.root, path)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return relative
+}
+
+type imageFileReference struct {
+	referrer string
+	recorded string
+}
+
+type referenceSummary struct {
+	Movies  int
+	Found   int
+	Missing []string // recorded paths
+	Tried   []string // translated path of each missing one, may be empty
+}
+
+// resolveReferences looks up the movies and image stacks, points movie records and the merged
+// SubFramePath/ImageFile to their paths relative to the dataset root and counts what is missing
+func resolveReferences(resolver *movieResolver, movies []movieRecord, imageFiles []imageFileReference, merged map[string]string) referenceSummary {
+	var summary referenceSummary
+	rewritten := make(map[string]string)
+	for i := range movies {
+		if movies[i].Path == "" {
+			continue
+		}
+		summary.Movies++
+		found, exists := resolver.resolve(movies[i].Path, movies[i].Source)
+		if !exists {
+			summary.Missing = append(summary.Missing, movies[i].Path)
+			summary.Tried = append(summary.Tried, found)
+			continue
+		}
+		summary.Found++
+		movies[i].Movie = resolver.relative(found)
+		rewritten[movies[i].Path] = movies[i].Movie
+	}
+	for _, image := range imageFiles {
+		if found, exists := resolver.resolve(image.recorded, image.referrer); exists {
+			rewritten[image.recorded] = resolver.relative(found)
+		}
+	}
+	for _, key := range []string{"SubFramePath", "ImageFile"} {
+		if relative, exists := rewritten[merged[key]]; exists {
+			merged[key] = relative
+		}
+	}
+	if summary.Movies > 0 {
+		merged["ReferencedMovies"] = strconv.Itoa(summary.Movies)
+		merged["ReferencedMoviesFound"] = strconv.Itoa(summary.Found)
+		merged["ReferencedMoviesMissing"] = strconv.Itoa(len(summary.Missing))
+	}
+	return summary
+}
+
+// explain lists the missing movies with the path they were looked for at
+func (summary referenceSummary) explain(w io.Writer) {
+	fmt.Fprintf(w, "referenced movies: %d found, %d missing\n", summary.Found, len(summary.Missing))
+	for i, recorded := range summary.Missing {
+		if i == 10 {
+			fmt.Fprintf(w, "  ... %d more\n", len(summary.Missing)-i)
+			break
+		}
+		tried := summary.Tried[i]
+		if tried == "" {
+			tried = "no WindowsPaths rule applies"
+		}
+		fmt.Fprintf(w, "  missing %s (%s)\n", recorded, tried)
+	}
+}
@@ -0,0 +1,55 @@
+package metadataparser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveReferences(t *testing.T) {
+	root := t.TempDir()
+	storage := t.TempDir()
+	files := []string{
+		filepath.Join(storage, "raw", "translated.tif"),
+		filepath.Join(root, "frames", "copied.tif"),
+		filepath.Join(root, "mdocs", "TS_1.mrc"),
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mdoc := filepath.Join(root, "mdocs", "TS_1.mrc.mdoc")
+	movie := func(recorded string) movieRecord {
+		record := newMovieRecord(mdoc)
+		record.setMdocValue("SubFramePath", recorded)
+		return record
+	}
+	movies := []movieRecord{
+		movie(`X:\Users\lab\raw\translated.tif`),
+		movie(`D:\frames\copied.tif`),
+		movie(`X:\Users\lab\raw\gone.tif`),
+		newMovieRecord(mdoc),
+	}
+	merged := map[string]string{"SubFramePath": `D:\frames\copied.tif`, "ImageFile": "TS_1.mrc"}
+	resolver := newMovieResolver(root, []pathmap.WindowsRule{{From: `X:\Users\lab`, To: storage}})
+
+	summary := resolveReferences(resolver, movies, []imageFileReference{{referrer: mdoc, recorded: "TS_1.mrc"}}, merged)
+	assert.Equal(t, 3, summary.Movies)
+	assert.Equal(t, 2, summary.Found)
+	assert.Equal(t, []string{`X:\Users\lab\raw\gone.tif`}, summary.Missing)
+	assert.Equal(t, []string{filepath.Join(storage, "raw", "gone.tif")}, summary.Tried)
+
+	assert.Equal(t, filepath.Join(storage, "raw", "translated.tif"), movies[0].Movie)
+	assert.Equal(t, "frames/copied.tif", movies[1].Movie)
+	assert.Equal(t, "gone.tif", movies[2].Movie)
+	assert.Equal(t, "frames/copied.tif", merged["SubFramePath"])
+	assert.Equal(t, "mdocs/TS_1.mrc", merged["ImageFile"])
+	assert.Equal(t, "1", merged["ReferencedMoviesMissing"])
+}
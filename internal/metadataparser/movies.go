@@ -0,0 +1,131 @@
+package metadataparser
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+)
+
+// movieRecord keeps the few per-movie values that are needed after the merge
+// to the dataset level (one EPU Data xml or one mdoc [ZValue]/[FrameSet] section).
+// Unknown numbers are NaN, lengths are in µm and the dose in e/Å².
+type movieRecord struct {
+	Source      string
+	Movie       string // file name of the movie itself, as far as the metadata tells, or its path below the dataset
+	Path        string // path of the movie as recorded on the acquisition computer, SerialEM only
+	Time        time.Time
+	LastFlash   time.Time // CFEG flash preceding this movie, EPU only
+	Defocus     float64
+	Dose        float64
+	StageX      float64
+	StageY      float64
+	BeamShiftX  float64
+	BeamShiftY  float64
+	ImageShiftX float64
+	ImageShiftY float64
+	GridSquare  string // EPU GridSquare folder
+	TiltSeries  string // mdoc the section belongs to
+}
+
+var gridSquarePattern = regexp.MustCompile(`GridSquare_(\d+)`)
+
+func newMovieRecord(source string) movieRecord {
+	nan := math.NaN()
+	return movieRecord{Source: source, Defocus: nan, Dose: nan, StageX: nan, StageY: nan,
+		BeamShiftX: nan, BeamShiftY: nan, ImageShiftX: nan, ImageShiftY: nan}
+}
+
+// sortMovies orders the movies by their metadata file, the sections of an mdoc keep their order. The files
+// are read in parallel, sorting them makes the groups, maps and tables the same on every run.
+func sortMovies(movies []movieRecord) {
+	sort.SliceStable(movies, func(i, j int) bool { return movies[i].Source < movies[j].Source })
+}
+
+// group is the set a movie is coloured by on the maps: its grid square for EPU, its tilt series for SerialEM/Tomo
+func (record movieRecord) group() string {
+	if record.GridSquare != "" {
+		return "GridSquare_" + record.GridSquare
+	}
+	return record.TiltSeries
+}
+
+// shift returns the beam-image shift used to target the movie, EPU (AFIS) uses the beam shift, SerialEM the image shift
+func (record movieRecord) shift() (float64, float64, bool) {
+	if !math.IsNaN(record.ImageShiftX) && !math.IsNaN(record.ImageShiftY) && (record.ImageShiftX != 0 || record.ImageShiftY != 0) {
+		return record.ImageShiftX, record.ImageShiftY, true
+	}
+	if !math.IsNaN(record.BeamShiftX) && !math.IsNaN(record.BeamShiftY) {
+		return record.BeamShiftX, record.BeamShiftY, true
+	}
+	return 0, 0, false
+}
+
+func parsePair(value string) (float64, float64) {
+	pair := strings.Fields(value)
+	if len(pair) != 2 {
+		return math.NaN(), math.NaN()
+	}
+	return parseNumber(pair[0]), parseNumber(pair[1])
+}
+
+func parseNumber(value string) float64 {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return number
+}
+
+// EPU stores lengths in m
+func xmlMovieRecord(filePath string, content map[string]string) movieRecord {
+	record := newMovieRecord(filePath)
+	record.Movie = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	if acquired, ok := parseDateTime(content["MicroscopeImage.microscopeData.acquisition.acquisitionDateTime"]); ok {
+		record.Time = acquired
+	}
+	if flash, ok := parseFlashStamp(content["CFEGFlashTimeStamp"]); ok {
+		record.LastFlash = flash
+	}
+	record.Defocus = parseNumber(content["MicroscopeImage.microscopeData.optics.Defocus"]) * 1e6
+	record.Dose = parseNumber(content["DoseOnCamera"])
+	record.StageX = parseNumber(content["MicroscopeImage.microscopeData.stage.Position.X"]) * 1e6
+	record.StageY = parseNumber(content["MicroscopeImage.microscopeData.stage.Position.Y"]) * 1e6
+	record.BeamShiftX = parseNumber(content["MicroscopeImage.microscopeData.optics.BeamShift._x"])
+	record.BeamShiftY = parseNumber(content["MicroscopeImage.microscopeData.optics.BeamShift._y"])
+	record.ImageShiftX = parseNumber(content["MicroscopeImage.microscopeData.optics.ImageShift._x"])
+	record.ImageShiftY = parseNumber(content["MicroscopeImage.microscopeData.optics.ImageShift._y"])
+	if square := gridSquarePattern.FindStringSubmatch(filePath); square != nil {
+		record.GridSquare = square[1]
+	}
+	return record
+}
+
+// setMdocValue fills the record from a key value line of its mdoc section, SerialEM already uses µm
+func (record *movieRecord) setMdocValue(key string, value string) {
+	switch key {
+	case "DateTime":
+		if acquired, ok := parseDateTime(value); ok {
+			record.Time = acquired
+		}
+	case "Defocus":
+		record.Defocus = parseNumber(value)
+	case "ExposureDose":
+		record.Dose = parseNumber(value)
+	case "StagePosition":
+		record.StageX, record.StageY = parsePair(value)
+	case "ImageShift":
+		record.ImageShiftX, record.ImageShiftY = parsePair(value)
+	case "Beamshift", "BeamShift":
+		record.BeamShiftX, record.BeamShiftY = parsePair(value)
+	case "SubFramePath":
+		// recorded on the acquisition computer, often a windows path
+		record.Path = strings.TrimSpace(value)
+		record.Movie = pathmap.Base(value)
+	}
+}
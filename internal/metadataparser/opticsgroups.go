@@ -0,0 +1,207 @@
+package metadataparser
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	OpticsGroupsGrid   = "grid"
+	OpticsGroupsKMeans = "kmeans"
+)
+
+// opticsGroups assigns every movie with a known beam-image shift to a 1-based optics group, 0 means unassigned
+type opticsGroups struct {
+	Assignment []int
+	Centres    []shiftPoint
+	// the movie files paired with the movies, below the dataset root, or the movie name if none was found
+	Movies []string
+}
+
+// kMeansShifts refines the given start centres with Lloyd's algorithm
+func kMeansShifts(points []shiftPoint, centres []shiftPoint) ([]int, []shiftPoint) {
+	assignment := make([]int, len(points))
+	for iteration := 0; iteration < 100; iteration++ {
+		changed := iteration == 0
+		for i, point := range points {
+			best, bestDistance := 0, math.Inf(1)
+			for c, centre := range centres {
+				distance := math.Hypot(point.X-centre.X, point.Y-centre.Y)
+				if distance < bestDistance {
+					best, bestDistance = c, distance
+				}
+			}
+			if assignment[i] != best {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([]shiftPoint, len(centres))
+		counts := make([]int, len(centres))
+		for i, point := range points {
+			sums[assignment[i]].X += point.X
+			sums[assignment[i]].Y += point.Y
+			counts[assignment[i]]++
+		}
+		for c := range centres {
+			if counts[c] > 0 {
+				centres[c] = shiftPoint{sums[c].X / float64(counts[c]), sums[c].Y / float64(counts[c])}
+			}
+		}
+	}
+	return orderClusters(assignment, centres)
+}
+
+// farthest point seeding, deterministic so reruns give the same groups
+func seedCentres(points []shiftPoint, k int) []shiftPoint {
+	centres := []shiftPoint{points[0]}
+	for len(centres) < k {
+		best, bestDistance := -1, -1.0
+		for i, point := range points {
+			nearest := math.Inf(1)
+			for _, centre := range centres {
+				nearest = min(nearest, math.Hypot(point.X-centre.X, point.Y-centre.Y))
+			}
+			if nearest > bestDistance {
+				best, bestDistance = i, nearest
+			}
+		}
+		if bestDistance <= 0 {
+			break
+		}
+		centres = append(centres, points[best])
+	}
+	return centres
+}
+
+// assignOpticsGroups clusters the per movie shifts. The grid method groups shifts that hit the
+// same position of the hole pattern (within radius), kmeans uses k groups or, for k <= 0, as many
+// as the grid method found.
+func assignOpticsGroups(movies []movieRecord, method string, k int, radius float64) (opticsGroups, error) {
+	var points []shiftPoint
+	var shifted []int
+	for i, movie := range movies {
+		if x, y, ok := movie.shift(); ok {
+			points = append(points, shiftPoint{x, y})
+			shifted = append(shifted, i)
+		}
+	}
+	groups := opticsGroups{Assignment: make([]int, len(movies))}
+	if len(points) == 0 {
+		return groups, nil
+	}
+	var assignment []int
+	switch method {
+	case OpticsGroupsGrid:
+		assignment, groups.Centres = clusterShifts(points, radius)
+	case OpticsGroupsKMeans:
+		if k <= 0 {
+			_, start := clusterShifts(points, radius)
+			assignment, groups.Centres = kMeansShifts(points, start)
+		} else {
+			assignment, groups.Centres = kMeansShifts(points, seedCentres(points, min(k, len(points))))
+		}
+	default:
+		return groups, fmt.Errorf("unknown optics group method %q, use %s or %s", method, OpticsGroupsGrid, OpticsGroupsKMeans)
+	}
+	for i, index := range shifted {
+		groups.Assignment[index] = assignment[i] + 1
+	}
+	return groups, nil
+}
+
+// WriteOpticsGroupsCSV writes the optics group and shift of every movie
+func (result *Result) WriteOpticsGroupsCSV(path string) error {
+	if result.optics == nil {
+		return fmt.Errorf("no optics groups were assigned")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	rows := [][]string{{"movie", "metadata_file", "optics_group", "shift_x", "shift_y"}}
+	for i, movie := range result.movies {
+		if result.optics.Assignment[i] == 0 {
+			continue
+		}
+		x, y, _ := movie.shift()
+		rows = append(rows, []string{movie.Movie, movie.Source, strconv.Itoa(result.optics.Assignment[i]),
+			strconv.FormatFloat(x, 'g', -1, 64), strconv.FormatFloat(y, 'g', -1, 64)})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WriteOpticsGroupsSTAR writes a RELION (3.1+) star file with an optics table and the group of every movie.
+// cs is the spherical aberration in mm and amplitudeContrast the fraction of amplitude contrast, as neither is
+// part of the extracted metadata. RELION would take a missing value as a setting, the file is then not written.
+func (result *Result) WriteOpticsGroupsSTAR(path string, cs string, amplitudeContrast float64) error {
+	if result.optics == nil {
+		return fmt.Errorf("no optics groups were assigned")
+	}
+	pixelSize := parseNumber(firstValue(result.merged, "PixelSpacing"))
+	if math.IsNaN(pixelSize) {
+		pixelSize = parseNumber(firstValue(result.merged, "MicroscopeImage.SpatialScale.pixelSize.x.numericValue")) * 1e10
+	}
+	voltage := parseNumber(firstValue(result.merged, "Voltage"))
+	if math.IsNaN(voltage) {
+		voltage = parseNumber(firstValue(result.merged, "MicroscopeImage.microscopeData.gun.AccelerationVoltage")) / 1000
+	}
+	sphericalAberration := parseNumber(cs)
+	var missing []string
+	for _, value := range []struct {
+		name   string
+		number float64
+	}{{"pixel size", pixelSize}, {"voltage", voltage}, {"Cs", sphericalAberration}} {
+		if math.IsNaN(value.number) {
+			missing = append(missing, value.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the optics table needs the %s of the dataset", strings.Join(missing, ", "))
+	}
+	if math.IsNaN(amplitudeContrast) || amplitudeContrast < 0 || amplitudeContrast > 1 {
+		return fmt.Errorf("amplitude contrast must be between 0 and 1, got %g", amplitudeContrast)
+	}
+
+	var builder strings.Builder
+	builder.WriteString("# version 30001\n\ndata_optics\n\nloop_\n")
+	builder.WriteString("_rlnOpticsGroupName #1\n_rlnOpticsGroup #2\n_rlnMicrographOriginalPixelSize #3\n")
+	builder.WriteString("_rlnVoltage #4\n_rlnSphericalAberration #5\n_rlnAmplitudeContrast #6\n")
+	for i := range result.optics.Centres {
+		fmt.Fprintf(&builder, "opticsGroup%d %d %s %s %s %s\n", i+1, i+1, starNumber(pixelSize), starNumber(voltage), starNumber(sphericalAberration), starNumber(amplitudeContrast))
+	}
+	builder.WriteString("\n\n# version 30001\n\ndata_movies\n\nloop_\n")
+	builder.WriteString("_rlnMicrographMovieName #1\n_rlnOpticsGroup #2\n")
+	for i := range result.movies {
+		if result.optics.Assignment[i] == 0 {
+			continue
+		}
+		fmt.Fprintf(&builder, "%s %d\n", result.optics.Movies[i], result.optics.Assignment[i])
+	}
+	builder.WriteString("\n")
+	return os.WriteFile(path, []byte(builder.String()), 0644)
+}
+
+// value of key, or its minimum if it varied within the dataset
+func firstValue(merged map[string]string, key string) string {
+	if value, exists := merged[key]; exists {
+		return value
+	}
+	return merged[key+"_min"]
+}
+
+func starNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
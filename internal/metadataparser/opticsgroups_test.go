@@ -0,0 +1,89 @@
+package metadataparser
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignOpticsGroups(t *testing.T) {
+	// four shot pattern over two holes and one movie without shift information
+	shifts := [][2]float64{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}, {-1.02, -1}, {0.98, -1}, {-0.98, 1}, {1.02, 1}}
+	var movies []movieRecord
+	for _, shift := range shifts {
+		movie := newMovieRecord("FoilHole.xml")
+		movie.BeamShiftX, movie.BeamShiftY = shift[0], shift[1]
+		movie.ImageShiftX, movie.ImageShiftY = 0, 0
+		movies = append(movies, movie)
+	}
+	movies = append(movies, newMovieRecord("unknown.xml"))
+
+	tests := []struct {
+		name       string
+		method     string
+		k          int
+		wantGroups []int
+		wantErr    bool
+	}{
+		{name: "grid", method: OpticsGroupsGrid, wantGroups: []int{1, 2, 3, 4, 1, 2, 3, 4, 0}},
+		{name: "kmeans from grid", method: OpticsGroupsKMeans, wantGroups: []int{1, 2, 3, 4, 1, 2, 3, 4, 0}},
+		{name: "kmeans two groups", method: OpticsGroupsKMeans, k: 2, wantGroups: []int{1, 1, 2, 2, 1, 1, 2, 2, 0}},
+		{name: "unknown method", method: "hexagon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := assignOpticsGroups(movies, tt.method, tt.k, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantGroups, groups.Assignment)
+		})
+	}
+}
+
+func TestWriteOpticsGroupsSTAR(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	var movies []string
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(source)), content, 0644))
+		movie := strings.TrimSuffix(filepath.Base(source), ".xml") + "_fractions.tiff"
+		assert.NoError(t, os.WriteFile(filepath.Join(data, movie), nil, 0644))
+		movies = append(movies, filepath.Join("Images-Disc1", "GridSquare_1", "Data", movie))
+	}
+
+	result, err := Extract(dataset, Options{
+		PathMappings: []pathmap.Rule{},
+		WindowsPaths: []pathmap.WindowsRule{},
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Workers:      4,
+		OpticsGroups: OpticsGroupsGrid,
+	})
+	assert.NoError(t, err)
+	star := filepath.Join(t.TempDir(), "optics.star")
+	assert.NoError(t, result.WriteOpticsGroupsSTAR(star, "2.7", 0.07))
+	content, err := os.ReadFile(star)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), " 2.700000 0.070000\n")
+	// the paired movie files, in the order of their metadata files
+	assert.Contains(t, string(content), "_rlnOpticsGroup #2\n"+movies[0]+" 1\n"+movies[1]+" ")
+
+	// a missing value is not written as 0
+	missing := filepath.Join(t.TempDir(), "missing.star")
+	assert.ErrorContains(t, result.WriteOpticsGroupsSTAR(missing, "", 0.1), "Cs")
+	assert.NoFileExists(t, missing)
+	assert.Error(t, result.WriteOpticsGroupsSTAR(missing, "2.7", 1.5))
+}
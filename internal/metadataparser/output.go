@@ -0,0 +1,50 @@
+package metadataparser
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Stdout as output path writes to the standard output instead of a file
+const Stdout = "-"
+
+// checkOutput fails early, before any file is read, if path exists and may not be overwritten
+func checkOutput(path string, force bool) error {
+	if path == Stdout || force {
+		return nil
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("refusing to overwrite %s: %w", path, fs.ErrExist)
+	}
+	return nil
+}
+
+// writeOutput writes content to path via a temporary file in the same directory, so readers never
+// see a partial file and a failed run keeps the previous one
+func writeOutput(path string, content []byte, force bool) error {
+	if path == Stdout {
+		_, err := os.Stdout.Write(append(content, '\n'))
+		return err
+	}
+	if err := checkOutput(path, force); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
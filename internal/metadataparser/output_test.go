@@ -0,0 +1,33 @@
+package metadataparser
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dataset_full.json")
+
+	assert.NoError(t, writeOutput(path, []byte("first"), false))
+	err := writeOutput(path, []byte("second"), false)
+	assert.True(t, errors.Is(err, fs.ErrExist), err)
+	assert.True(t, errors.Is(checkOutput(path, false), fs.ErrExist))
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "first", string(content))
+
+	assert.NoError(t, writeOutput(path, []byte("second"), true))
+	content, _ = os.ReadFile(path)
+	assert.Equal(t, "second", string(content))
+	assert.NoError(t, checkOutput(Stdout, false))
+
+	// no temporary files are left behind, also not by a failed write
+	assert.Error(t, writeOutput(filepath.Join(dir, "missing", "x.json"), nil, false))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,93 @@
+package metadataparser
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// the kinds of ProgressEvent
+const (
+	// the metadata files were found, Total is known
+	ProgressDiscovered = "discovered"
+	// File was read
+	ProgressParsed = "parsed"
+	// File could not be read, Error tells why
+	ProgressFailed = "failed"
+	// the files were merged to the dataset level
+	ProgressMerged = "merged"
+)
+
+// ProgressEvent is passed to Options.OnProgress while an extraction runs
+type ProgressEvent struct {
+	Event string `json:"event"`
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+	// files read, files that failed and files found so far
+	Parsed int64 `json:"parsed"`
+	Failed int64 `json:"failed"`
+	Total  int64 `json:"total"`
+}
+
+// ProgressTracker counts the metadata files read, Total is known once the files are found; read both atomically
+type ProgressTracker struct {
+	Total     int64
+	Completed int64
+}
+
+// progressReporter updates the tracker and passes the events to the callback, one at a time
+type progressReporter struct {
+	tracker  *ProgressTracker
+	callback func(ProgressEvent)
+
+	mu     sync.Mutex
+	parsed int64
+	failed int64
+	total  int64
+}
+
+func newProgressReporter(opts Options) *progressReporter {
+	return &progressReporter{tracker: opts.Progress, callback: opts.OnProgress}
+}
+
+// discovered starts counting total files
+func (p *progressReporter) discovered(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parsed, p.failed, p.total = 0, 0, int64(total)
+	if p.tracker != nil {
+		atomic.StoreInt64(&p.tracker.Completed, 0)
+		atomic.StoreInt64(&p.tracker.Total, p.total)
+	}
+	p.report(ProgressEvent{Event: ProgressDiscovered})
+}
+
+// read counts a file, err is nil if it could be read
+func (p *progressReporter) read(file string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tracker != nil {
+		atomic.AddInt64(&p.tracker.Completed, 1)
+	}
+	if err != nil {
+		p.failed++
+		p.report(ProgressEvent{Event: ProgressFailed, File: file, Error: err.Error()})
+		return
+	}
+	p.parsed++
+	p.report(ProgressEvent{Event: ProgressParsed, File: file})
+}
+
+func (p *progressReporter) merged() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report(ProgressEvent{Event: ProgressMerged})
+}
+
+// report fills in the counts, the caller holds the lock
+func (p *progressReporter) report(event ProgressEvent) {
+	if p.callback == nil {
+		return
+	}
+	event.Parsed, event.Failed, event.Total = p.parsed, p.failed, p.total
+	p.callback(event)
+}
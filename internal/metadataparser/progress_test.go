@@ -0,0 +1,50 @@
+package metadataparser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressEvents(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	content, err := os.ReadFile(sources[0])
+	assert.NoError(t, err)
+	good := filepath.Join(data, filepath.Base(sources[0]))
+	broken := filepath.Join(data, filepath.Base(sources[1]))
+	assert.NoError(t, os.WriteFile(good, content, 0644))
+	assert.NoError(t, os.WriteFile(broken, []byte("<MicroscopeImage"), 0644))
+
+	var events []ProgressEvent
+	tracker := &ProgressTracker{}
+	_, err = Extract(dataset, Options{
+		PathMappings: []pathmap.Rule{},
+		WindowsPaths: []pathmap.WindowsRule{},
+		Progress:     tracker,
+		OnProgress:   func(event ProgressEvent) { events = append(events, event) },
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, events, 4)
+	assert.Equal(t, ProgressEvent{Event: ProgressDiscovered, Total: 2}, events[0])
+	var parsed, failed ProgressEvent
+	for _, event := range events[1:3] {
+		if event.Event == ProgressParsed {
+			parsed = event
+		} else {
+			failed = event
+		}
+	}
+	assert.Equal(t, good, parsed.File)
+	assert.Equal(t, ProgressFailed, failed.Event)
+	assert.Equal(t, broken, failed.File)
+	assert.NotEmpty(t, failed.Error)
+	assert.Equal(t, ProgressEvent{Event: ProgressMerged, Parsed: 1, Failed: 1, Total: 2}, events[3])
+	assert.Equal(t, ProgressTracker{Total: 2, Completed: 2}, *tracker)
+}
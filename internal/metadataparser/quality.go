@@ -0,0 +1,331 @@
+package metadataparser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-extractor-life/internal/keypattern"
+)
+
+// ErrQualityErrors is returned when the quality check found errors and failing on them was requested.
+var ErrQualityErrors = errors.New("quality check reported errors")
+
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// QualityRule checks the value(s) of Key with Comparator against Threshold.
+// Scope "file" evaluates every metadata file, "dataset" the merged metadata;
+// "varies" rules always look across files. Key may contain * wildcards and a
+// rule is only applied when the optional When key is present.
+type QualityRule struct {
+	Name       string `json:"name"`
+	Key        string `json:"key"`
+	Comparator string `json:"comparator"`
+	Threshold  string `json:"threshold,omitempty"`
+	Severity   string `json:"severity"`
+	Scope      string `json:"scope,omitempty"`
+	When       string `json:"when,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+type QualityFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Message  string `json:"message"`
+}
+
+type QualityReport struct {
+	Rules    []QualityRule    `json:"rules"`
+	Findings []QualityFinding `json:"findings"`
+	Errors   int              `json:"errors"`
+	Warnings int              `json:"warnings"`
+	Infos    int              `json:"infos"`
+}
+
+var builtinQualityRules = []QualityRule{
+	{Name: "zero-dose", Key: "DoseOnCamera", Comparator: "<=", Threshold: "0", Severity: SeverityError, Scope: "file", Message: "movie without dose"},
+	{Name: "zero-dose-mdoc", Key: "ExposureDose", Comparator: "<=", Threshold: "0", Severity: SeverityError, Scope: "file", Message: "movie without dose"},
+	{Name: "defocus-range", Key: "AppliedDefocus", Comparator: "outside", Threshold: "-5E-06:0", Severity: SeverityWarning, Scope: "file", Message: "defocus outside of the target range"},
+	{Name: "defocus-range-mdoc", Key: "TargetDefocus", Comparator: "outside", Threshold: "-5:0", Severity: SeverityWarning, Scope: "file", Message: "defocus outside of the target range"},
+	{Name: "energy-filter-slit-change", Key: "MicroscopeImage.microscopeData.optics.EnergyFilter.EnergySelectionSlitWidth", Comparator: "varies", Severity: SeverityWarning, Message: "energy filter slit changed during the session"},
+	{Name: "energy-filter-slit-change-mdoc", Key: "FilterSlitAndLoss", Comparator: "varies", Severity: SeverityWarning, Message: "energy filter slit changed during the session"},
+	{Name: "detector-change", Key: "MicroscopeImage.microscopeData.acquisition.camera.Name", Comparator: "varies", Severity: SeverityError, Message: "detector changed during the session"},
+	{Name: "detector-change-mdoc", Key: "CameraIndex", Comparator: "varies", Severity: SeverityError, Message: "detector changed during the session"},
+	{Name: "missing-gain-reference", Key: "Detectors[*].GainReference", Comparator: "missing", Severity: SeverityWarning, Scope: "dataset", When: "Detectors[*].CommercialName", Message: "no gain reference recorded"},
+	{Name: "missing-gain-reference-mdoc", Key: "GainReference", Comparator: "missing", Severity: SeverityWarning, Scope: "dataset", When: "SubFramePath", Message: "no gain reference recorded"},
+	{Name: "pixel-spacing-inconsistent", Key: "MicroscopeImage.SpatialScale.pixelSize.x.numericValue", Comparator: "varies", Severity: SeverityError, Message: "pixel size differs between files"},
+	{Name: "pixel-spacing-inconsistent-mdoc", Key: "PixelSpacing", Comparator: "varies", Severity: SeverityError, Message: "pixel size differs between files"},
+}
+
+// LoadQualityRules returns the built-in rules, extended or overridden (by name) with the rules of a json file.
+func LoadQualityRules(path string) ([]QualityRule, error) {
+	rules := append([]QualityRule(nil), builtinQualityRules...)
+	if path == "" {
+		return rules, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var custom []QualityRule
+	err = json.Unmarshal(content, &custom)
+	if err != nil {
+		return nil, fmt.Errorf("rule file %s: %w", path, err)
+	}
+	for _, rule := range custom {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule file %s: %w", path, err)
+		}
+		replaced := false
+		for i := range rules {
+			if rules[i].Name == rule.Name {
+				rules[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (rule QualityRule) validate() error {
+	if rule.Name == "" || rule.Key == "" {
+		return fmt.Errorf("rules need a name and a key")
+	}
+	switch rule.Comparator {
+	case "<", "<=", ">", ">=", "==", "!=", "missing", "varies":
+	case "outside":
+		if _, _, ok := parseRange(rule.Threshold); !ok {
+			return fmt.Errorf("rule %s: threshold of outside must be min:max", rule.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown comparator %q", rule.Name, rule.Comparator)
+	}
+	switch rule.Severity {
+	case SeverityInfo, SeverityWarning, SeverityError:
+	default:
+		return fmt.Errorf("rule %s: unknown severity %q", rule.Name, rule.Severity)
+	}
+	switch rule.Scope {
+	case "", "file", "dataset":
+	default:
+		return fmt.Errorf("rule %s: unknown scope %q", rule.Name, rule.Scope)
+	}
+	return nil
+}
+
+func parseRange(threshold string) (float64, float64, bool) {
+	bounds := strings.Split(threshold, ":")
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	low, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	high, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return low, high, true
+}
+
+type qualityChecker struct {
+	rules    []QualityRule
+	keys     []*regexp.Regexp
+	when     []*regexp.Regexp
+	findings []QualityFinding
+	// distinct values per varies rule, value -> first file it was seen in
+	seen []map[string]string
+}
+
+func newQualityChecker(rules []QualityRule) *qualityChecker {
+	checker := &qualityChecker{rules: rules}
+	for _, rule := range rules {
+		checker.keys = append(checker.keys, keypattern.Compile(rule.Key))
+		if rule.When != "" {
+			checker.when = append(checker.when, keypattern.Compile(rule.When))
+		} else {
+			checker.when = append(checker.when, nil)
+		}
+		checker.seen = append(checker.seen, make(map[string]string))
+	}
+	return checker
+}
+
+// matching keys of a map, per file maps of mdocs only keep _min/_max for values that changed
+func matchingValues(pattern *regexp.Regexp, content map[string]string) map[string][]string {
+	values := make(map[string][]string)
+	for key, value := range content {
+		base := key
+		if pattern.MatchString(key) {
+			values[base] = append(values[base], value)
+			continue
+		}
+		base = strings.TrimSuffix(strings.TrimSuffix(key, "_min"), "_max")
+		if base != key && pattern.MatchString(base) {
+			values[base] = append(values[base], value)
+		}
+	}
+	return values
+}
+
+func hasKey(pattern *regexp.Regexp, content map[string]string) bool {
+	return len(matchingValues(pattern, content)) > 0
+}
+
+func (rule QualityRule) violates(value string) bool {
+	value = strings.TrimSpace(value)
+	number, numErr := strconv.ParseFloat(value, 64)
+	if rule.Comparator == "outside" {
+		low, high, _ := parseRange(rule.Threshold)
+		return numErr == nil && (number < low || number > high)
+	}
+	limit, limitErr := strconv.ParseFloat(strings.TrimSpace(rule.Threshold), 64)
+	if numErr != nil || limitErr != nil {
+		switch rule.Comparator {
+		case "==":
+			return value == rule.Threshold
+		case "!=":
+			return value != rule.Threshold
+		}
+		return false
+	}
+	switch rule.Comparator {
+	case "<":
+		return number < limit
+	case "<=":
+		return number <= limit
+	case ">":
+		return number > limit
+	case ">=":
+		return number >= limit
+	case "==":
+		return number == limit
+	case "!=":
+		return number != limit
+	}
+	return false
+}
+
+func (c *qualityChecker) add(rule QualityRule, file string, key string, value string) {
+	message := rule.Message
+	if message == "" {
+		message = fmt.Sprintf("%s %s %s", rule.Key, rule.Comparator, rule.Threshold)
+	}
+	c.findings = append(c.findings, QualityFinding{Rule: rule.Name, Severity: rule.Severity, File: file, Key: key, Value: value, Message: message})
+}
+
+func (c *qualityChecker) check(i int, file string, content map[string]string) {
+	rule := c.rules[i]
+	if c.when[i] != nil && !hasKey(c.when[i], content) {
+		return
+	}
+	if rule.Comparator == "missing" {
+		if !hasKey(c.keys[i], content) {
+			c.add(rule, file, rule.Key, "")
+		}
+		return
+	}
+	for key, values := range matchingValues(c.keys[i], content) {
+		sort.Strings(values)
+		for _, value := range values {
+			if rule.violates(value) {
+				c.add(rule, file, key, value)
+				break
+			}
+		}
+	}
+}
+
+// observe runs the file rules on the metadata of a single file and collects values for varies rules
+func (c *qualityChecker) observe(file string, content map[string]string) {
+	for i, rule := range c.rules {
+		if rule.Comparator == "varies" {
+			if c.when[i] != nil && !hasKey(c.when[i], content) {
+				continue
+			}
+			for _, values := range matchingValues(c.keys[i], content) {
+				for _, value := range values {
+					value = strings.TrimSpace(value)
+					if _, exists := c.seen[i][value]; !exists {
+						c.seen[i][value] = file
+					}
+				}
+			}
+			continue
+		}
+		if rule.Scope == "file" || rule.Scope == "" {
+			c.check(i, file, content)
+		}
+	}
+}
+
+// finish runs the dataset rules on the merged metadata and returns the report
+// report finishes a copy of the checker, so more files can be observed afterwards (see Watch)
+func (c *qualityChecker) report(merged map[string]string) *QualityReport {
+	finished := *c
+	finished.findings = append([]QualityFinding(nil), c.findings...)
+	return finished.finish(merged)
+}
+
+func (c *qualityChecker) finish(merged map[string]string) *QualityReport {
+	for i, rule := range c.rules {
+		switch {
+		case rule.Comparator == "varies":
+			if len(c.seen[i]) > 1 {
+				var values []string
+				for value := range c.seen[i] {
+					values = append(values, value)
+				}
+				sort.Strings(values)
+				c.add(rule, "", rule.Key, strings.Join(values, ", "))
+			}
+		case rule.Scope == "dataset":
+			c.check(i, "", merged)
+		}
+	}
+	sort.SliceStable(c.findings, func(i, j int) bool {
+		if c.findings[i].Rule != c.findings[j].Rule {
+			return c.findings[i].Rule < c.findings[j].Rule
+		}
+		return c.findings[i].File < c.findings[j].File
+	})
+	report := &QualityReport{Rules: c.rules, Findings: c.findings}
+	if report.Findings == nil {
+		report.Findings = []QualityFinding{}
+	}
+	for _, finding := range report.Findings {
+		switch finding.Severity {
+		case SeverityError:
+			report.Errors++
+		case SeverityWarning:
+			report.Warnings++
+		default:
+			report.Infos++
+		}
+	}
+	return report
+}
+
+func (report *QualityReport) writeJSON(path string) error {
+	content, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,89 @@
+package metadataparser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQualityChecker(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []QualityRule
+		files     []map[string]string
+		merged    map[string]string
+		wantRules []string
+	}{
+		{
+			name:      "zero dose",
+			rules:     builtinQualityRules,
+			files:     []map[string]string{{"DoseOnCamera": "4.2"}, {"DoseOnCamera": "0"}},
+			merged:    map[string]string{},
+			wantRules: []string{"zero-dose"},
+		},
+		{
+			name:      "mdoc min max",
+			rules:     builtinQualityRules,
+			files:     []map[string]string{{"ExposureDose_min": "0", "ExposureDose_max": "3.1", "TargetDefocus": "-2"}},
+			merged:    map[string]string{},
+			wantRules: []string{"zero-dose-mdoc"},
+		},
+		{
+			name:  "detector and pixel size change",
+			rules: builtinQualityRules,
+			files: []map[string]string{
+				{"MicroscopeImage.microscopeData.acquisition.camera.Name": "EF-Falcon", "MicroscopeImage.SpatialScale.pixelSize.x.numericValue": "4.15E-11"},
+				{"MicroscopeImage.microscopeData.acquisition.camera.Name": "BM-Falcon", "MicroscopeImage.SpatialScale.pixelSize.x.numericValue": "4.15E-11"},
+			},
+			merged:    map[string]string{},
+			wantRules: []string{"detector-change"},
+		},
+		{
+			name:      "missing gain reference",
+			rules:     builtinQualityRules,
+			files:     []map[string]string{{"AppliedDefocus": "-1E-06"}},
+			merged:    map[string]string{"Detectors[EF-Falcon].CommercialName": "Falcon 4i"},
+			wantRules: []string{"missing-gain-reference"},
+		},
+		{
+			name:      "dataset rule",
+			rules:     []QualityRule{{Name: "few-movies", Key: "NumberOfMovies", Comparator: "<", Threshold: "100", Severity: SeverityInfo, Scope: "dataset"}},
+			files:     []map[string]string{{"NumberOfMovies": "1"}},
+			merged:    map[string]string{"NumberOfMovies": "2"},
+			wantRules: []string{"few-movies"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newQualityChecker(tt.rules)
+			for i, content := range tt.files {
+				checker.observe(string(rune('a'+i))+".xml", content)
+			}
+			report := checker.finish(tt.merged)
+			var matched []string
+			for _, finding := range report.Findings {
+				matched = append(matched, finding.Rule)
+			}
+			assert.Equal(t, tt.wantRules, matched)
+		})
+	}
+}
+
+func TestLoadQualityRules(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`[{"name":"zero-dose","key":"DoseOnCamera","comparator":"<","threshold":"1","severity":"warning","scope":"file"},
+		{"name":"slit","key":"*EnergySelectionSlitWidth","comparator":"outside","threshold":"5:20","severity":"error"}]`), 0644)
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`[{"name":"broken","key":"Dose","comparator":"~","severity":"error"}]`), 0644)
+
+	rules, err := LoadQualityRules(valid)
+	assert.NoError(t, err)
+	assert.Len(t, rules, len(builtinQualityRules)+1)
+	assert.Equal(t, SeverityWarning, rules[0].Severity)
+
+	_, err = LoadQualityRules(invalid)
+	assert.Error(t, err)
+}
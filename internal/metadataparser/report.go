@@ -0,0 +1,240 @@
+package metadataparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the report is a single html page, all styles and plots (svg) are inline
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Session report {{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; font-size: 0.9em; }
+td, th { border: 1px solid #ddd; padding: 0.2em 0.6em; text-align: left; }
+th { background: #f3f3f3; }
+.error { color: #b00020; }
+.warning { color: #a66300; }
+.plots { display: flex; flex-wrap: wrap; gap: 2em; }
+.plots figure { margin: 0; }
+svg text { font-size: 11px; fill: #444; }
+</style>
+</head>
+<body>
+<h1>Session report {{.Name}}</h1>
+<p>Generated {{.Generated}} from {{.Movies}} movies.</p>
+<h2>Summary</h2>
+<table>
+{{range .Summary}}<tr><th>{{.Key}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+<h2>Plots</h2>
+<div class="plots">
+<figure>{{.DefocusHistogram}}<figcaption>Defocus (µm)</figcaption></figure>
+<figure>{{.DoseOverTime}}<figcaption>Dose per movie (e/Å²) over time</figcaption></figure>
+<figure>{{.StagePositions}}<figcaption>Stage positions of collected targets (µm)</figcaption></figure>
+</div>
+<h2>Warnings</h2>
+{{if .Findings}}<table>
+<tr><th>Severity</th><th>Rule</th><th>File</th><th>Key</th><th>Value</th><th>Message</th></tr>
+{{range .Findings}}<tr class="{{.Severity}}"><td>{{.Severity}}</td><td>{{.Rule}}</td><td>{{.File}}</td><td>{{.Key}}</td><td>{{.Value}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p>No warnings.</p>{{end}}
+</body>
+</html>
+`))
+
+type reportEntry struct {
+	Key   string
+	Value string
+}
+
+type reportPage struct {
+	Name             string
+	Generated        string
+	Movies           int
+	Summary          []reportEntry
+	DefocusHistogram template.HTML
+	DoseOverTime     template.HTML
+	StagePositions   template.HTML
+	Findings         []QualityFinding
+}
+
+// WriteReport renders the html session report, oscem is the converted (OSC-EM) metadata used for the summary.
+func (result *Result) WriteReport(path string, oscem []byte) error {
+	page := reportPage{
+		Name:      result.name,
+		Generated: time.Now().Format(time.RFC3339),
+		Movies:    len(result.movies),
+	}
+	var converted interface{}
+	if err := json.Unmarshal(oscem, &converted); err == nil {
+		flattenReport("", converted, &page.Summary)
+	}
+	if result.timed {
+		page.Summary = append(page.Summary,
+			reportEntry{"session.start", result.timeline.Start.Format(time.RFC3339)},
+			reportEntry{"session.end", result.timeline.End.Format(time.RFC3339)},
+			reportEntry{"session.effective_collection_time", result.timeline.Effective.Round(time.Minute).String()},
+			reportEntry{"session.acquisition_gaps", fmt.Sprint(len(result.timeline.Gaps))},
+		)
+	}
+
+	var defocus, dose []float64
+	var doseTimes []time.Time
+	for _, movie := range result.movies {
+		if !math.IsNaN(movie.Defocus) {
+			defocus = append(defocus, movie.Defocus)
+		}
+		if !math.IsNaN(movie.Dose) && !movie.Time.IsZero() {
+			dose = append(dose, movie.Dose)
+			doseTimes = append(doseTimes, movie.Time)
+		}
+	}
+	page.DefocusHistogram = svgHistogram(defocus, 20)
+	page.DoseOverTime = svgTimeSeries(doseTimes, dose)
+	page.StagePositions = template.HTML(renderStageMap(result.movies, plotWidth, false))
+	if result.Quality != nil {
+		page.Findings = result.Quality.Findings
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = reportTemplate.Execute(file, page)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// flattens the nested OSC-EM json into dotted keys, value/unit pairs are joined
+func flattenReport(prefix string, value interface{}, entries *[]reportEntry) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if number, ok := typed["value"]; ok && len(typed) <= 2 {
+			unit, _ := typed["unit"].(string)
+			*entries = append(*entries, reportEntry{prefix, strings.TrimSpace(fmt.Sprint(number) + " " + unit)})
+			return
+		}
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if prefix != "" {
+				child = prefix + "." + key
+			}
+			flattenReport(child, typed[key], entries)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			flattenReport(fmt.Sprintf("%s[%d]", prefix, i), item, entries)
+		}
+	case nil:
+	default:
+		*entries = append(*entries, reportEntry{prefix, fmt.Sprint(typed)})
+	}
+}
+
+const (
+	plotWidth  = 360.0
+	plotHeight = 240.0
+	plotMargin = 40.0
+)
+
+func emptyPlot() template.HTML {
+	return template.HTML(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f"><text x="%.0f" y="%.0f" text-anchor="middle">no data</text></svg>`,
+		plotWidth, plotHeight, plotWidth/2, plotHeight/2))
+}
+
+func valueRange(values []float64) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, value := range values {
+		low = min(low, value)
+		high = max(high, value)
+	}
+	if low == high {
+		low, high = low-0.5, high+0.5
+	}
+	return low, high
+}
+
+// scale maps a value of [low, high] onto the plot area, flipped for the y axis
+func scale(value, low, high float64, y bool) float64 {
+	span := plotWidth - 2*plotMargin
+	if y {
+		span = plotHeight - 2*plotMargin
+		return plotHeight - plotMargin - (value-low)/(high-low)*span
+	}
+	return plotMargin + (value-low)/(high-low)*span
+}
+
+func plotFrame(builder *strings.Builder, xLow, xHigh, yLow, yHigh string) {
+	fmt.Fprintf(builder, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f">`, plotWidth, plotHeight)
+	fmt.Fprintf(builder, `<rect x="%.0f" y="%.0f" width="%.0f" height="%.0f" fill="none" stroke="#999"/>`,
+		plotMargin, plotMargin, plotWidth-2*plotMargin, plotHeight-2*plotMargin)
+	fmt.Fprintf(builder, `<text x="%.0f" y="%.0f">%s</text>`, plotMargin, plotHeight-plotMargin+14, template.HTMLEscapeString(xLow))
+	fmt.Fprintf(builder, `<text x="%.0f" y="%.0f" text-anchor="end">%s</text>`, plotWidth-plotMargin, plotHeight-plotMargin+14, template.HTMLEscapeString(xHigh))
+	fmt.Fprintf(builder, `<text x="%.0f" y="%.0f" text-anchor="end">%s</text>`, plotMargin-4, plotHeight-plotMargin, template.HTMLEscapeString(yLow))
+	fmt.Fprintf(builder, `<text x="%.0f" y="%.0f" text-anchor="end">%s</text>`, plotMargin-4, plotMargin+8, template.HTMLEscapeString(yHigh))
+}
+
+func svgHistogram(values []float64, bins int) template.HTML {
+	if len(values) == 0 {
+		return emptyPlot()
+	}
+	low, high := valueRange(values)
+	counts := make([]int, bins)
+	for _, value := range values {
+		bin := int((value - low) / (high - low) * float64(bins))
+		counts[min(max(bin, 0), bins-1)]++
+	}
+	peak := 0
+	for _, count := range counts {
+		peak = max(peak, count)
+	}
+	var builder strings.Builder
+	plotFrame(&builder, fmt.Sprintf("%.2f", low), fmt.Sprintf("%.2f", high), "0", fmt.Sprint(peak))
+	barWidth := (plotWidth - 2*plotMargin) / float64(bins)
+	for i, count := range counts {
+		top := scale(float64(count), 0, float64(peak), true)
+		fmt.Fprintf(&builder, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#4a7ab5"/>`,
+			plotMargin+float64(i)*barWidth, top, barWidth-1, plotHeight-plotMargin-top)
+	}
+	builder.WriteString(`</svg>`)
+	return template.HTML(builder.String())
+}
+
+func svgTimeSeries(times []time.Time, values []float64) template.HTML {
+	if len(values) == 0 {
+		return emptyPlot()
+	}
+	seconds := make([]float64, len(times))
+	for i, stamp := range times {
+		seconds[i] = float64(stamp.Unix())
+	}
+	tLow, tHigh := valueRange(seconds)
+	low, high := valueRange(values)
+	var builder strings.Builder
+	plotFrame(&builder, time.Unix(int64(tLow), 0).UTC().Format("01-02 15:04"), time.Unix(int64(tHigh), 0).UTC().Format("01-02 15:04"),
+		fmt.Sprintf("%.2f", low), fmt.Sprintf("%.2f", high))
+	for i := range values {
+		fmt.Fprintf(&builder, `<circle cx="%.1f" cy="%.1f" r="2" fill="#b5534a"/>`, scale(seconds[i], tLow, tHigh, false), scale(values[i], low, high, true))
+	}
+	builder.WriteString(`</svg>`)
+	return template.HTML(builder.String())
+}
@@ -0,0 +1,35 @@
+package metadataparser
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteReport(t *testing.T) {
+	result, err := Extract("../../tests/combine", Options{QualityReport: filepath.Join(t.TempDir(), "quality.json")})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "report.html")
+	oscem := []byte(`{"instrument": {"acceleration_voltage": {"unit": "kV", "value": 300}}, "acquisition": {"detectors": [{"name": "Falcon 4i"}]}}`)
+	if err := result.WriteReport(path, oscem); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(content)
+
+	assert.Contains(t, page, "<th>instrument.acceleration_voltage</th><td>300 kV</td>")
+	assert.Contains(t, page, "<th>acquisition.detectors[0].name</th><td>Falcon 4i</td>")
+	assert.Contains(t, page, "missing-gain-reference-mdoc")
+	assert.Equal(t, 3, strings.Count(page, "<svg"))
+	// self-contained: nothing is loaded from elsewhere
+	assert.False(t, regexp.MustCompile(`(src|href)=`).MatchString(page))
+}
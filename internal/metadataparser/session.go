@@ -0,0 +1,513 @@
+package metadataparser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/archive"
+	"github.com/osc-em/oscem-extractor-life/internal/manifest"
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+)
+
+// session is a dataset being extracted with the settings of the run. An extraction discovers the
+// metadata files, parses them into a collection and finishes the collection into a Result;
+// Extract does that once, Watch repeatedly.
+type session struct {
+	opts Options
+	// the directory as given and as absolute path, the mirror search needs the absolute one
+	directory string
+	absolute  string
+	name      string
+	mappings  []pathmap.Rule
+	// the dataset directory and the EPU mirror in use, found by discover
+	roots         []string
+	fullOut       string
+	zipOut        string
+	archiveFormat string
+	qualityRules  []QualityRule
+	// parsed files of earlier runs, nil without Options.Cache
+	cache    *fileCache
+	log      *slog.Logger
+	progress *progressReporter
+}
+
+// newSession checks the directory and all settings before any file is read
+func newSession(topLevelDirectory string, opts Options) (*session, error) {
+	log := opts.logger()
+	// Check if the provided directory exists
+	fileInfo, err := os.Stat(topLevelDirectory)
+	if os.IsNotExist(err) {
+		log.Error("Directory does not exist", "directory", topLevelDirectory)
+		return nil, err
+	} else if err != nil {
+		log.Error("Cannot read directory", "directory", topLevelDirectory, "error", err)
+		return nil, err
+	}
+
+	// Check if the provided path is a directory
+	if !fileInfo.IsDir() {
+		log.Error("Not a directory", "directory", topLevelDirectory)
+		return nil, fmt.Errorf("%s is not a directory", topLevelDirectory)
+	}
+	// this part is to make sure there is no confusion on the instrument computer search when running on the Athena server folder with "./"
+	directory_safe, _ := filepath.Abs(topLevelDirectory)
+	correct := strings.Split(directory_safe, string(filepath.Separator))
+	s := &session{opts: opts, directory: topLevelDirectory, absolute: directory_safe, name: correct[len(correct)-1], log: log, progress: newProgressReporter(opts)}
+
+	// the settings are resolved by the caller, the config files are not read here
+	s.mappings = opts.PathMappings
+	parallel := opts.EPUFolder
+	if parallel == "" && len(s.mappings) == 0 {
+		log.Warn("No path config available, we suggest using either --epu or the config to provide the path where EPU mirrors the datasets and stores xmls")
+	}
+	if parallel != "" {
+		s.mappings = append(s.mappings, pathmap.RootRule(parallel))
+	}
+
+	if opts.Manifest != "" {
+		if s.opts.ManifestChecksum == "" {
+			s.opts.ManifestChecksum = manifest.SHA256
+		}
+		if s.opts.ManifestWorkers <= 0 {
+			s.opts.ManifestWorkers = 4
+		}
+		err = manifest.Check(s.opts.ManifestFormat, s.opts.ManifestChecksum)
+		if err != nil {
+			log.Error("Invalid manifest settings", "error", err)
+			return nil, err
+		}
+	}
+
+	s.fullOut = opts.FullMetadataOut
+	if opts.WriteFullMetadata || s.fullOut != "" {
+		s.opts.WriteFullMetadata = true
+		if s.fullOut == "" && s.name != "" {
+			s.fullOut = s.name + "_full.json"
+		} else if s.fullOut == "" {
+			s.fullOut = "Dataset_out.json"
+			log.Warn("Name generation failed, returning to default", "path", s.fullOut)
+		}
+		err = checkOutput(s.fullOut, opts.Force)
+		if err != nil {
+			log.Error("Error writing JSON to file", "error", err)
+			return nil, err
+		}
+	}
+
+	s.zipOut, s.archiveFormat = opts.ZipOut, opts.ArchiveFormat
+	if opts.CreateZip || s.zipOut != "" {
+		s.opts.CreateZip = true
+		if s.zipOut == "" {
+			s.zipOut = "xmls.zip"
+			if format, _ := archive.Format("", s.archiveFormat); format == archive.FormatTarGz {
+				s.zipOut = "xmls.tar.gz"
+			}
+		}
+		s.archiveFormat, err = archive.Format(s.zipOut, s.archiveFormat)
+		if err == nil {
+			err = archive.Check(s.archiveFormat, opts.CompressionLevel)
+		}
+		if err != nil {
+			log.Error("Invalid archive settings", "error", err)
+			return nil, err
+		}
+	}
+
+	s.qualityRules, err = LoadQualityRules(opts.QualityRules)
+	if err != nil {
+		log.Error("Could not load the quality rules", "path", opts.QualityRules, "error", err)
+		return nil, err
+	}
+
+	if opts.Cache || opts.CacheDir != "" {
+		dir := opts.CacheDir
+		if dir == "" {
+			dir, err = DefaultCacheDir()
+			if err != nil {
+				log.Error("No cache directory available", "error", err)
+				return nil, err
+			}
+		}
+		s.cache = openCache(cachePath(dir, s.absolute), log)
+	}
+	return s, nil
+}
+
+// discover finds the metadata folders of the dataset and its EPU mirror and the metadata files in them
+func (s *session) discover(ctx context.Context) ([]string, error) {
+	metadataFolderRegex := s.opts.MetadataFolderRegex
+	var dataFolders []string
+	dataFolders, err := findDataFolders(ctx, s.directory, dataFolders, metadataFolderRegex)
+	if err != nil {
+		// a cancelled search is no reason to doubt the directory
+		if ctx.Err() == nil {
+			s.log.Error("Folder search failed - is this the correct directory?", "directory", s.directory, "error", err)
+		}
+		return nil, err
+	}
+	s.roots = []string{s.absolute}
+	if len(s.mappings) > 0 {
+		candidates, err := pathmap.Candidates(s.mappings, s.directory)
+		if err != nil {
+			s.log.Error("The EPU mirror path mapping failed", "error", err)
+			return nil, err
+		}
+		if s.opts.ExplainPaths {
+			pathmap.Explain(os.Stderr, s.absolute, candidates)
+		}
+		mirror, found := pathmap.First(candidates)
+		if len(candidates) > 0 && !found {
+			err = fmt.Errorf("none of the %d mapped EPU mirror folders exists", len(candidates))
+			s.log.Error("There should be a folder on your instrument control computer with the same name - something went wrong here (see --explain-paths)", "error", err)
+			return nil, err
+		}
+		if found {
+			s.roots = append(s.roots, mirror.Path)
+			searched := len(dataFolders)
+			dataFolders, err = findDataFolders(ctx, mirror.Path, dataFolders, metadataFolderRegex)
+			if err != nil {
+				s.log.Error("There should be a folder on your instrument control computer with the same name - something went wrong here", "mirror", mirror.Path, "error", err)
+				return nil, err
+			}
+			if s.opts.ExplainPaths {
+				fmt.Fprintf(os.Stderr, "metadata folders in the mirror: %d\n", len(dataFolders)-searched)
+				for _, folder := range dataFolders[searched:] {
+					fmt.Fprintln(os.Stderr, " ", folder)
+				}
+			}
+		}
+	}
+	dataFolders = append(dataFolders, s.directory)
+
+	allfiles, err := collectAllFiles(ctx, dataFolders)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		s.log.Error("Could not collect files", "folders", dataFolders, "error", err)
+	}
+	return allfiles, nil
+}
+
+// parsedFile is the flat map of one xml or mdoc file and the movies it describes
+type parsedFile struct {
+	path    string
+	content map[string]string
+	movies  []movieRecord
+	mdoc    bool
+}
+
+// parseFile reads one metadata file
+func parseFile(filePath string) (parsedFile, error) {
+	switch filepath.Ext(filePath) {
+	case ".xml":
+		xmlContent, err := process_xml(filePath)
+		if err != nil {
+			return parsedFile{}, err
+		}
+		// skipped xmls as the BatchPositionsList describe no acquisition
+		if xmlContent == nil {
+			return parsedFile{path: filePath}, nil
+		}
+		return parsedFile{path: filePath, content: xmlContent, movies: []movieRecord{xmlMovieRecord(filePath, xmlContent)}}, nil
+	case ".mdoc":
+		mdocContent, movies, err := process_mdoc(filePath)
+		if err != nil {
+			return parsedFile{}, err
+		}
+		return parsedFile{path: filePath, content: mdocContent, movies: movies, mdoc: true}, nil
+	default:
+		return parsedFile{}, fmt.Errorf("unknown file type: %s", filePath)
+	}
+}
+
+// parse reads the files, taking those that did not change from the cache, passes them to add and logs
+// those that fail
+func (s *session) parse(ctx context.Context, files []string, add func(parsedFile)) []Diagnostic {
+	parse := parseFile
+	if s.cache != nil {
+		parse = s.cache.parse
+	}
+	failed := parseFiles(ctx, files, s.opts.Workers, s.opts.FileTimeout, s.progress, parse, add)
+	for _, diagnostic := range failed {
+		diagnostic.log(s.log)
+	}
+	return failed
+}
+
+// saveCache drops the files that are gone from the cache and writes it, allfiles are all files of the dataset
+func (s *session) saveCache(allfiles []string) {
+	if s.cache == nil {
+		return
+	}
+	if s.cache.hits+s.cache.misses > 0 {
+		s.log.Info("Cache used", "unchanged", s.cache.hits, "files", s.cache.hits+s.cache.misses, "parsed", s.cache.misses)
+		s.cache.hits, s.cache.misses = 0, 0
+	}
+	s.cache.retain(allfiles)
+	if err := s.cache.save(); err != nil {
+		s.log.Warn("Error writing the cache", "path", s.cache.path, "error", err)
+	}
+}
+
+// parseOutcome is a parsed file, or why it could not be read
+type parseOutcome struct {
+	file    parsedFile
+	failure *Diagnostic
+}
+
+// parseFiles reads the files with workers in parallel and passes every parsed file to add, in the calling
+// goroutine, as soon as it is read; the channels are bounded, so only a few files are held at a time. Files
+// that fail are left out and returned as diagnostics. Once ctx is done the remaining files are left out as well.
+func parseFiles(ctx context.Context, files []string, workers int, timeout time.Duration, progress *progressReporter, parse func(string) (parsedFile, error), add func(parsedFile)) []Diagnostic {
+	if workers < 1 {
+		workers = defaultWorkers()
+	}
+	jobs := make(chan string, workers)
+	go func() {
+		defer close(jobs)
+		for _, filePath := range files {
+			select {
+			case jobs <- filePath:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	outcomes := make(chan parseOutcome, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go readin(ctx, jobs, outcomes, &wg, progress, func(path string) (parsedFile, error) {
+			return parseContext(ctx, path, timeout, parse)
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+	var failed []Diagnostic
+	for outcome := range outcomes {
+		if outcome.failure != nil {
+			failed = append(failed, *outcome.failure)
+		} else {
+			add(outcome.file)
+		}
+	}
+	sortDiagnostics(failed)
+	return failed
+}
+
+// defaultWorkers is the number of files read in parallel unless Options.Workers is set
+func defaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// collection merges the parsed files of a dataset as they are read, xmls and mdocs are merged separately. Of
+// every file only what the outputs need (path, movies, image file, quality observations) is kept, not its
+// content. failed are the files that could not be read, incomplete is set if not all files were read.
+type collection struct {
+	paths      []string
+	movies     []movieRecord
+	imageFiles []imageFileReference
+	quality    *qualityChecker
+	failed     []Diagnostic
+	incomplete bool
+	xml        datasetMerger
+	mdoc       datasetMerger
+}
+
+func newCollection(rules []QualityRule) *collection {
+	return &collection{quality: newQualityChecker(rules)}
+}
+
+func (c *collection) add(file parsedFile) {
+	c.paths = append(c.paths, file.path)
+	c.movies = append(c.movies, file.movies...)
+	if imageFile, exists := file.content["ImageFile"]; file.mdoc && exists {
+		c.imageFiles = append(c.imageFiles, imageFileReference{referrer: file.path, recorded: imageFile})
+	}
+	c.quality.observe(file.path, file.content)
+	if file.mdoc {
+		c.mdoc.add(file.content)
+	} else {
+		c.xml.add(file.content)
+	}
+}
+
+// finish merges the collection to the dataset level, adds the derived keys and writes the requested outputs.
+// allfiles are all metadata files found, including those that could not be parsed.
+func (s *session) finish(allfiles []string, c *collection) (*Result, error) {
+	opts := s.opts
+	if err := checkFailures(c.failed, len(allfiles), opts.Strict, opts.MaxFailureRatio); err != nil {
+		s.log.Error("Too many metadata files could not be read", "error", err)
+		return nil, err
+	}
+	readFiles, imageFiles := c.paths, c.imageFiles
+	// the movies are changed below, those of the collection are kept for the next finish (see Watch)
+	movies := append([]movieRecord(nil), c.movies...)
+	sortMovies(movies)
+	if c.incomplete && (opts.CreateZip || opts.Manifest != "") {
+		s.log.Warn("Skipping the archive and the manifest of the incomplete extraction")
+	}
+	if opts.CreateZip && readFiles != nil && !c.incomplete {
+		err := writeArchive(s.zipOut, s.archiveFormat, opts.CompressionLevel, readFiles, s.roots)
+		if err != nil {
+			s.log.Error("Error writing the archive", "path", s.zipOut, "error", err)
+			return nil, err
+		}
+	}
+
+	var out map[string]string
+	if c.mdoc.count > 0 && c.xml.count == 0 {
+		out = c.mdoc.result()
+	} else if c.xml.count > 0 && c.mdoc.count == 0 {
+		out = c.xml.result()
+	} else if c.xml.count > 0 && c.mdoc.count > 0 {
+		out = c.xml.result()
+		b := c.mdoc.result()
+		for x, y := range b {
+			out[x] = y
+		}
+	} else {
+		s.log.Error("Something went wrong, nothing was read out")
+		return nil, errNothingRead
+	}
+	s.progress.merged()
+	if !opts.XMLTypes {
+		dropCustomDataTypes(out)
+	}
+	if c.incomplete {
+		out["Incomplete"] = "true"
+	}
+
+	// find the movies, the paths are those of the acquisition computer
+	resolver := newMovieResolver(s.absolute, opts.WindowsPaths)
+	references := resolveReferences(resolver, movies, imageFiles, out)
+	if opts.ExplainPaths && references.Movies > 0 {
+		references.explain(os.Stderr)
+	} else if len(references.Missing) > 0 {
+		s.log.Warn("Referenced movies were not found, add WindowsPaths rules to the config (see --explain-paths)",
+			"missing", len(references.Missing), "movies", references.Movies)
+	}
+
+	if opts.Manifest != "" && !c.incomplete {
+		files := manifestFiles(readFiles, resolver, movies)
+		if opts.ManifestFormat == manifest.FormatBagIt {
+			var outside []string
+			files, outside = bagPayload(s.absolute, files)
+			if len(outside) > 0 {
+				s.log.Warn("Files outside of the dataset are left out of the BagIt manifest, they are not part of its payload",
+					"files", len(outside), "first", outside[0])
+			}
+		}
+		err := writeManifest(opts.Manifest, opts.ManifestFormat, opts.ManifestChecksum, opts.ManifestWorkers, s.absolute, files)
+		if err != nil {
+			s.log.Error("Error writing the checksum manifest", "path", opts.Manifest, "error", err)
+		}
+	}
+
+	var completeness *CompletenessReport
+	if opts.CompletenessReport != "" || opts.MinCompleteness > 0 {
+		completeness = checkCompleteness(resolver, movies, imageFiles)
+		completeness.addTo(out)
+		s.log.Info(completeness.summary())
+		if opts.CompletenessReport != "" {
+			err := completeness.writeJSON(opts.CompletenessReport)
+			if err != nil {
+				s.log.Error("Error writing completeness report to file", "path", opts.CompletenessReport, "error", err)
+			}
+		}
+		if completeness.Ratio < opts.MinCompleteness {
+			return nil, fmt.Errorf("%w: %.3f < %.3f", ErrIncomplete, completeness.Ratio, opts.MinCompleteness)
+		}
+	}
+
+	// session timeline from the per movie timestamps
+	sessionTimeline, timed := analyseTimeline(movies, opts.GapThreshold)
+	if timed {
+		sessionTimeline.addTo(out)
+		if opts.TimelineCSV != "" {
+			err := sessionTimeline.writeCSV(opts.TimelineCSV)
+			if err != nil {
+				s.log.Error("Error writing timeline to file", "path", opts.TimelineCSV, "error", err)
+			}
+		}
+	} else if opts.TimelineCSV != "" {
+		s.log.Warn("No acquisition timestamps found, skipping timeline output")
+	}
+
+	var optics *opticsGroups
+	if opts.OpticsGroups != "" {
+		groups, err := assignOpticsGroups(movies, opts.OpticsGroups, opts.OpticsGroupsK, opts.OpticsGroupsRadius)
+		if err != nil {
+			s.log.Error("Optics group assignment failed", "error", err)
+			return nil, err
+		}
+		out["NumberOfOpticsGroups"] = strconv.Itoa(len(groups.Centres))
+		groups.Movies = make([]string, len(movies))
+		for i, movie := range movies {
+			groups.Movies[i] = movie.Movie
+			if found, exists := moviePath(resolver, movie); exists {
+				groups.Movies[i] = resolver.relative(found)
+			}
+		}
+		optics = &groups
+	}
+
+	gain := resolveGain(out, opts.GainFlipRotate, opts.GainFlipRotateDefault, s.log)
+
+	report := c.quality.report(out)
+	if opts.QualityReport != "" {
+		err := report.writeJSON(opts.QualityReport)
+		if err != nil {
+			s.log.Error("Error writing quality report to file", "path", opts.QualityReport, "error", err)
+		}
+	}
+	if (opts.QualityReport != "" || opts.FailOnQualityErrors) && (report.Errors > 0 || report.Warnings > 0) {
+		s.log.Warn("Quality check", "errors", report.Errors, "warnings", report.Warnings)
+	}
+	if opts.FailOnQualityErrors && report.Errors > 0 {
+		return nil, ErrQualityErrors
+	}
+
+	jsonData, err := json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		s.log.Error("Error marshaling to JSON", "error", err)
+		return nil, err
+	}
+	if opts.WriteFullMetadata {
+		err = writeOutput(s.fullOut, jsonData, opts.Force)
+		if err != nil {
+			s.log.Error("Error writing JSON to file", "path", s.fullOut, "error", err)
+			return nil, err
+		}
+		if s.fullOut != Stdout {
+			s.log.Info("Extracted full data has been written", "path", s.fullOut)
+		}
+	}
+	return &Result{
+		Metadata:       jsonData,
+		Quality:        report,
+		GainFlipRotate: gain,
+		Completeness:   completeness,
+		Diagnostics:    c.failed,
+		Incomplete:     c.incomplete,
+		name:           s.name,
+		merged:         out,
+		movies:         movies,
+		timeline:       sessionTimeline,
+		timed:          timed,
+		optics:         optics,
+	}, nil
+}
@@ -0,0 +1,57 @@
+package metadataparser
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionIgnoresConfigFile(t *testing.T) {
+	// the mirror of the user config does not exist, the extraction must not look for it
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("HOME", home)
+	path := filepath.Join(home, "oscem-extractor-life", "oscem-extractor-life.conf")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	content := `{"MPCPATH": "/nonexistent/epu/"}`
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	s, err := newSession("../../tests/xml", Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	assert.NoError(t, err)
+	assert.Empty(t, s.mappings)
+	_, err = Extract("../../tests/xml", Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	assert.NoError(t, err)
+}
+
+func TestSessionNotADirectory(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	file := filepath.Join(t.TempDir(), "file.xml")
+	assert.NoError(t, os.WriteFile(file, []byte("<xml/>"), 0644))
+
+	s, err := newSession(file, Options{Logger: log})
+	assert.Nil(t, s)
+	assert.ErrorContains(t, err, "is not a directory")
+	_, err = Extract(file, Options{Logger: log})
+	assert.Error(t, err)
+
+	// a path below a file fails in Stat with an error that is not IsNotExist
+	s, err = newSession(filepath.Join(file, "below"), Options{Logger: log})
+	assert.Nil(t, s)
+	assert.Error(t, err)
+}
+
+func TestSessionXMLTypes(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	result, err := Extract("../../tests/xml", Options{Logger: log})
+	assert.NoError(t, err)
+	assert.NotContains(t, result.merged, "DoseOnCamera.@type")
+	assert.Contains(t, result.merged, "MicroscopeImage.microscopeData.optics.Apertures.@nil")
+
+	result, err = Extract("../../tests/xml", Options{Logger: log, XMLTypes: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "b:double", result.merged["DoseOnCamera.@type"])
+}
@@ -0,0 +1,79 @@
+package metadataparser
+
+import (
+	"math"
+	"sort"
+)
+
+type shiftPoint struct {
+	X float64
+	Y float64
+}
+
+// clusterShifts groups beam-image shifts that lie within radius of a cluster centre,
+// multi-shot patterns target the same shift positions in every hole so this recovers them.
+// A radius <= 0 uses 10% of the largest shift distance from the origin. It returns the
+// cluster index per point and the cluster centres, ordered by centre position.
+func clusterShifts(points []shiftPoint, radius float64) ([]int, []shiftPoint) {
+	if len(points) == 0 {
+		return nil, nil
+	}
+	if radius <= 0 {
+		for _, point := range points {
+			radius = max(radius, math.Hypot(point.X, point.Y))
+		}
+		radius *= 0.1
+		if radius == 0 {
+			radius = 1e-9
+		}
+	}
+	var centres []shiftPoint
+	var counts []int
+	assignment := make([]int, len(points))
+	for i, point := range points {
+		best, bestDistance := -1, math.Inf(1)
+		for c, centre := range centres {
+			distance := math.Hypot(point.X-centre.X, point.Y-centre.Y)
+			if distance <= radius && distance < bestDistance {
+				best, bestDistance = c, distance
+			}
+		}
+		if best < 0 {
+			centres = append(centres, point)
+			counts = append(counts, 1)
+			assignment[i] = len(centres) - 1
+			continue
+		}
+		// running mean keeps the centre in the middle of its members
+		counts[best]++
+		centres[best].X += (point.X - centres[best].X) / float64(counts[best])
+		centres[best].Y += (point.Y - centres[best].Y) / float64(counts[best])
+		assignment[i] = best
+	}
+	return orderClusters(assignment, centres)
+}
+
+// renumbers clusters by their centre (y, then x) so the numbering does not depend on read order
+func orderClusters(assignment []int, centres []shiftPoint) ([]int, []shiftPoint) {
+	order := make([]int, len(centres))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := centres[order[i]], centres[order[j]]
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+	renumber := make([]int, len(centres))
+	sorted := make([]shiftPoint, len(centres))
+	for newIndex, oldIndex := range order {
+		renumber[oldIndex] = newIndex
+		sorted[newIndex] = centres[oldIndex]
+	}
+	for i := range assignment {
+		assignment[i] = renumber[assignment[i]]
+	}
+	return assignment, sorted
+}
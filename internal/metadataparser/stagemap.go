@@ -0,0 +1,137 @@
+package metadataparser
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// colours for grid squares/tilt series and shift clusters, reused cyclically
+var mapPalette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// a 3 mm grid, drawn as outline when all targets are on it
+const gridRadius = 1500.0
+
+// WriteStageMap writes an svg map of all acquisition positions, coloured by grid square or tilt series.
+// With overlay the beam-image shift cluster of every movie is drawn as a ring around its position.
+func (result *Result) WriteStageMap(path string, overlay bool) error {
+	return os.WriteFile(path, []byte(renderStageMap(result.movies, 800, overlay)), 0644)
+}
+
+func renderStageMap(movies []movieRecord, size float64, overlay bool) string {
+	var placed []movieRecord
+	for _, movie := range movies {
+		if !math.IsNaN(movie.StageX) && !math.IsNaN(movie.StageY) {
+			placed = append(placed, movie)
+		}
+	}
+	legendWidth := 180.0
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="sans-serif" font-size="11">`, size+legendWidth, size)
+	if len(placed) == 0 {
+		fmt.Fprintf(&builder, `<text x="%.0f" y="%.0f" text-anchor="middle">no stage positions</text></svg>`, size/2, size/2)
+		return builder.String()
+	}
+
+	// equal scaling on both axes, centred on the targets
+	xLow, xHigh, yLow, yHigh := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, movie := range placed {
+		xLow, xHigh = min(xLow, movie.StageX), max(xHigh, movie.StageX)
+		yLow, yHigh = min(yLow, movie.StageY), max(yHigh, movie.StageY)
+	}
+	onGrid := math.Max(math.Max(math.Abs(xLow), math.Abs(xHigh)), math.Max(math.Abs(yLow), math.Abs(yHigh))) <= gridRadius
+	span := max(xHigh-xLow, yHigh-yLow, 1) * 1.1
+	centreX, centreY := (xLow+xHigh)/2, (yLow+yHigh)/2
+	margin := 30.0
+	toX := func(x float64) float64 { return margin + (x-centreX+span/2)/span*(size-2*margin) }
+	toY := func(y float64) float64 { return size - margin - (y-centreY+span/2)/span*(size-2*margin) }
+
+	fmt.Fprintf(&builder, `<rect x="%.0f" y="%.0f" width="%.0f" height="%.0f" fill="none" stroke="#999"/>`, margin, margin, size-2*margin, size-2*margin)
+	if onGrid && span > gridRadius {
+		fmt.Fprintf(&builder, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="none" stroke="#ccc" stroke-dasharray="4 4"/>`,
+			toX(0), toY(0), gridRadius/span*(size-2*margin))
+	}
+	fmt.Fprintf(&builder, `<text x="%.0f" y="%.0f">x %.1f µm</text>`, margin, size-margin+16, centreX-span/2)
+	fmt.Fprintf(&builder, `<text x="%.0f" y="%.0f" text-anchor="end">x %.1f µm</text>`, size-margin, size-margin+16, centreX+span/2)
+	fmt.Fprintf(&builder, `<text x="%.0f" y="%.0f">y %.1f µm</text>`, margin, margin-8, centreY+span/2)
+
+	groups := make(map[string]int)
+	var groupNames []string
+	for _, movie := range placed {
+		if _, exists := groups[movie.group()]; !exists {
+			groups[movie.group()] = 0
+			groupNames = append(groupNames, movie.group())
+		}
+	}
+	sort.Strings(groupNames)
+	for i, name := range groupNames {
+		groups[name] = i
+	}
+
+	var clusters []int
+	var centres []shiftPoint
+	if overlay {
+		var shifts []shiftPoint
+		var shifted []int
+		for i, movie := range placed {
+			if x, y, ok := movie.shift(); ok {
+				shifts = append(shifts, shiftPoint{x, y})
+				shifted = append(shifted, i)
+			}
+		}
+		assignment, found := clusterShifts(shifts, 0)
+		centres = found
+		clusters = make([]int, len(placed))
+		for i := range clusters {
+			clusters[i] = -1
+		}
+		for i, index := range shifted {
+			clusters[index] = assignment[i]
+		}
+	}
+
+	for i, movie := range placed {
+		colour := mapPalette[groups[movie.group()]%len(mapPalette)]
+		fmt.Fprintf(&builder, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s</title></circle>`,
+			toX(movie.StageX), toY(movie.StageY), colour, template.HTMLEscapeString(movie.Source))
+		if overlay && clusters[i] >= 0 {
+			fmt.Fprintf(&builder, `<circle cx="%.1f" cy="%.1f" r="6" fill="none" stroke="%s"/>`,
+				toX(movie.StageX), toY(movie.StageY), mapPalette[clusters[i]%len(mapPalette)])
+		}
+	}
+
+	// legend, long lists are cut
+	legendX, legendY := size+10, margin
+	for i, name := range groupNames {
+		if i == 20 {
+			fmt.Fprintf(&builder, `<text x="%.0f" y="%.0f">… %d more</text>`, legendX, legendY, len(groupNames)-i)
+			legendY += 16
+			break
+		}
+		label := name
+		if label == "" {
+			label = "unknown"
+		}
+		fmt.Fprintf(&builder, `<circle cx="%.0f" cy="%.0f" r="4" fill="%s"/><text x="%.0f" y="%.0f">%s</text>`,
+			legendX+4, legendY-4, mapPalette[i%len(mapPalette)], legendX+14, legendY, template.HTMLEscapeString(label))
+		legendY += 16
+	}
+	if overlay {
+		legendY += 10
+		for i, centre := range centres {
+			if i == 20 {
+				break
+			}
+			fmt.Fprintf(&builder, `<circle cx="%.0f" cy="%.0f" r="5" fill="none" stroke="%s"/><text x="%.0f" y="%.0f">shift %d (%.3g, %.3g)</text>`,
+				legendX+4, legendY-4, mapPalette[i%len(mapPalette)], legendX+14, legendY, i+1, centre.X, centre.Y)
+			legendY += 16
+		}
+	}
+	builder.WriteString(`</svg>`)
+	return builder.String()
+}
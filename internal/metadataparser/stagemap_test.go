@@ -0,0 +1,67 @@
+package metadataparser
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterShifts(t *testing.T) {
+	// two holes with the same three shot pattern, read in mixed order
+	points := []shiftPoint{{0, 1}, {-1, 0}, {1, 0}, {1.02, 0.01}, {0.01, 0.98}, {-0.99, 0}}
+	assignment, centres := clusterShifts(points, 0)
+
+	assert.Len(t, centres, 3)
+	assert.Equal(t, []int{2, 0, 1, 1, 2, 0}, assignment)
+
+	assignment, centres = clusterShifts(nil, 0)
+	assert.Nil(t, assignment)
+	assert.Nil(t, centres)
+}
+
+func TestRenderStageMap(t *testing.T) {
+	movies := []movieRecord{
+		newMovieRecord("GridSquare_1/Data/FoilHole_1_Data_1_1.xml"),
+		newMovieRecord("GridSquare_2/Data/FoilHole_2_Data_2_2.xml"),
+		newMovieRecord("GridSquare_2/Data/FoilHole_3_Data_3_3.xml"),
+	}
+	for i := range movies {
+		movies[i].GridSquare = gridSquarePattern.FindStringSubmatch(movies[i].Source)[1]
+		movies[i].StageX, movies[i].StageY = float64(i*10), float64(-i*10)
+		movies[i].BeamShiftX, movies[i].BeamShiftY = 0.1, 0
+	}
+
+	svg := renderStageMap(movies, 400, true)
+	assert.Contains(t, svg, "GridSquare_1")
+	assert.Contains(t, svg, "GridSquare_2")
+	assert.Contains(t, svg, "shift 1")
+	assert.Equal(t, 3, strings.Count(svg, "<title>"))
+
+	assert.Contains(t, renderStageMap([]movieRecord{newMovieRecord("a.xml")}, 400, false), "no stage positions")
+}
+
+func TestStageMapDeterministic(t *testing.T) {
+	// the files are read in parallel, the movies arrive in any order
+	var movies []movieRecord
+	shots := [][2]float64{{0, 1}, {-1, 0}, {1, 0}}
+	for i := 0; i < 12; i++ {
+		movie := newMovieRecord(fmt.Sprintf("GridSquare_%d/Data/FoilHole_%d_Data_1_1.xml", i%2+1, i))
+		movie.GridSquare = gridSquarePattern.FindStringSubmatch(movie.Source)[1]
+		movie.StageX, movie.StageY = float64(i), float64(i%3)
+		movie.BeamShiftX, movie.BeamShiftY = shots[i%3][0]+float64(i)*0.001, shots[i%3][1]
+		movies = append(movies, movie)
+	}
+	render := func(seed int64) string {
+		shuffled := append([]movieRecord(nil), movies...)
+		rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		sortMovies(shuffled)
+		return renderStageMap(shuffled, 400, true)
+	}
+	want := render(1)
+	for seed := int64(2); seed < 6; seed++ {
+		assert.Equal(t, want, render(seed))
+	}
+}
@@ -0,0 +1,153 @@
+package metadataparser
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default pause between two movies that is reported as an acquisition gap
+const defaultGapThreshold = 10 * time.Minute
+
+type timelineGap struct {
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Cause    string
+}
+
+type throughputBin struct {
+	Start  time.Time
+	End    time.Time
+	Movies int
+}
+
+type timeline struct {
+	Start     time.Time
+	End       time.Time
+	Movies    int
+	Effective time.Duration
+	Gaps      []timelineGap
+	Bins      []throughputBin
+	Flashes   []time.Time
+}
+
+func parseDateTime(value string) (time.Time, bool) {
+	for _, format := range timeformats {
+		parsed, err := time.Parse(format, strings.TrimSpace(value))
+		if err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// CFEGFlashTimeStamp is stored by EPU as microseconds since the unix epoch
+func parseFlashStamp(value string) (time.Time, bool) {
+	stamp, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || stamp <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(stamp), true
+}
+
+func analyseTimeline(records []movieRecord, gapThreshold time.Duration) (timeline, bool) {
+	if gapThreshold <= 0 {
+		gapThreshold = defaultGapThreshold
+	}
+	var timed []movieRecord
+	flashSeen := make(map[int64]bool)
+	var tl timeline
+	for _, record := range records {
+		if !record.Time.IsZero() {
+			timed = append(timed, record)
+		}
+		if !record.LastFlash.IsZero() && !flashSeen[record.LastFlash.UnixMicro()] {
+			flashSeen[record.LastFlash.UnixMicro()] = true
+			tl.Flashes = append(tl.Flashes, record.LastFlash)
+		}
+	}
+	if len(timed) == 0 {
+		return tl, false
+	}
+	sort.Slice(timed, func(i, j int) bool { return timed[i].Time.Before(timed[j].Time) })
+	sort.Slice(tl.Flashes, func(i, j int) bool { return tl.Flashes[i].Before(tl.Flashes[j]) })
+
+	tl.Movies = len(timed)
+	tl.Start = timed[0].Time
+	tl.End = timed[len(timed)-1].Time
+	tl.Effective = tl.End.Sub(tl.Start)
+	for i := 1; i < len(timed); i++ {
+		pause := timed[i].Time.Sub(timed[i-1].Time)
+		if pause < gapThreshold {
+			continue
+		}
+		gap := timelineGap{Start: timed[i-1].Time, End: timed[i].Time, Duration: pause, Cause: "pause"}
+		for _, flash := range tl.Flashes {
+			if !flash.Before(gap.Start) && !flash.After(gap.End) {
+				gap.Cause = "CFEG flash"
+				break
+			}
+		}
+		tl.Gaps = append(tl.Gaps, gap)
+		tl.Effective -= pause
+	}
+
+	// every hour from start to end has a bin, the hours of a gap are kept with 0 movies
+	for binStart := tl.Start.Truncate(time.Hour); !binStart.After(tl.End); binStart = binStart.Add(time.Hour) {
+		tl.Bins = append(tl.Bins, throughputBin{Start: binStart, End: binStart.Add(time.Hour)})
+	}
+	first := tl.Bins[0].Start
+	for _, record := range timed {
+		tl.Bins[int(record.Time.Sub(first)/time.Hour)].Movies++
+	}
+	return tl, true
+}
+
+// adds the timeline summary to the dataset level metadata
+func (tl timeline) addTo(out map[string]string) {
+	var gapTotal time.Duration
+	for _, gap := range tl.Gaps {
+		gapTotal += gap.Duration
+	}
+	peak := 0
+	for _, bin := range tl.Bins {
+		peak = max(peak, bin.Movies)
+	}
+	out["CollectionStart"] = tl.Start.Format(time.RFC3339)
+	out["CollectionEnd"] = tl.End.Format(time.RFC3339)
+	out["EffectiveCollectionHours"] = strconv.FormatFloat(tl.Effective.Hours(), 'f', 16, 64)
+	if tl.Effective > 0 {
+		out["MoviesPerHour"] = strconv.FormatFloat(float64(tl.Movies)/tl.Effective.Hours(), 'f', 16, 64)
+	}
+	out["MoviesPerHourPeak"] = strconv.Itoa(peak)
+	out["AcquisitionGaps"] = strconv.Itoa(len(tl.Gaps))
+	out["AcquisitionGapsTotalHours"] = strconv.FormatFloat(gapTotal.Hours(), 'f', 16, 64)
+	if len(tl.Flashes) > 0 {
+		out["CFEGFlashes"] = strconv.Itoa(len(tl.Flashes))
+	}
+}
+
+// writeCSV stores the hourly throughput and the detected gaps, one row each
+func (tl timeline) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	rows := [][]string{{"kind", "start", "end", "movies", "duration_s", "cause"}}
+	for _, bin := range tl.Bins {
+		rows = append(rows, []string{"throughput", bin.Start.Format(time.RFC3339), bin.End.Format(time.RFC3339), strconv.Itoa(bin.Movies), "3600", ""})
+	}
+	for _, gap := range tl.Gaps {
+		rows = append(rows, []string{"gap", gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339), "0", strconv.FormatFloat(gap.Duration.Seconds(), 'f', 0, 64), gap.Cause})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,78 @@
+package metadataparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyseTimeline(t *testing.T) {
+	start := time.Date(2024, 9, 1, 6, 0, 0, 0, time.UTC)
+	flash := start.Add(50 * time.Minute)
+
+	tests := []struct {
+		name          string
+		offsets       []time.Duration
+		flash         time.Time
+		threshold     time.Duration
+		wantGaps      []string
+		wantEffective time.Duration
+		wantBins      []int
+	}{
+		{
+			name:          "continuous",
+			offsets:       []time.Duration{0, time.Minute, 2 * time.Minute},
+			wantEffective: 2 * time.Minute,
+			wantBins:      []int{3},
+		},
+		{
+			name:          "flash gap",
+			offsets:       []time.Duration{0, 5 * time.Minute, 70 * time.Minute, 75 * time.Minute},
+			flash:         flash,
+			wantGaps:      []string{"CFEG flash"},
+			wantEffective: 10 * time.Minute,
+			wantBins:      []int{2, 2},
+		},
+		{
+			name:          "hours without movies",
+			offsets:       []time.Duration{0, 5 * time.Minute, 190 * time.Minute},
+			wantGaps:      []string{"pause"},
+			wantEffective: 5 * time.Minute,
+			wantBins:      []int{2, 0, 0, 1},
+		},
+		{
+			name:          "custom threshold",
+			offsets:       []time.Duration{0, 3 * time.Minute, 6 * time.Minute},
+			threshold:     2 * time.Minute,
+			wantGaps:      []string{"pause", "pause"},
+			wantEffective: 0,
+			wantBins:      []int{3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []movieRecord
+			// reversed to make sure the analysis does not rely on read order
+			for i := len(tt.offsets) - 1; i >= 0; i-- {
+				records = append(records, movieRecord{Time: start.Add(tt.offsets[i]), LastFlash: tt.flash})
+			}
+			tl, ok := analyseTimeline(records, tt.threshold)
+			assert.True(t, ok)
+			var causes []string
+			for _, gap := range tl.Gaps {
+				causes = append(causes, gap.Cause)
+			}
+			assert.Equal(t, tt.wantGaps, causes)
+			assert.Equal(t, tt.wantEffective, tl.Effective)
+			var bins []int
+			for _, bin := range tl.Bins {
+				bins = append(bins, bin.Movies)
+			}
+			assert.Equal(t, tt.wantBins, bins)
+		})
+	}
+
+	_, ok := analyseTimeline([]movieRecord{{Source: "untimed.xml"}}, 0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,219 @@
+package metadataparser
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchOptions are the settings of Watch on top of those of the extraction
+type WatchOptions struct {
+	// how often the folders are scanned for new and changed files, defaults to 10 seconds
+	PollInterval time.Duration
+	// how often the metadata is rewritten while files arrive, 0 to write it only at the end of the session
+	WriteInterval time.Duration
+	// the session is taken as ended once no file arrived or changed for this long, 0 to watch until stopped
+	IdleTimeout time.Duration
+	// called with every new result, final is set for the last one written at the end of the session
+	OnUpdate func(result *Result, final bool) error
+}
+
+// fileState tells whether a file changed since it was parsed
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watcher keeps the parsed files of a live session, only new and changed files are parsed again
+type watcher struct {
+	session  *session
+	allfiles []string
+	states   map[string]fileState
+	// files that could not be parsed, retried once they change (e.g. written completely), and why
+	failed      map[string]fileState
+	diagnostics map[string]Diagnostic
+	// parsed files by path and the order they were first seen in, which is also the merge order
+	parsed map[string]parsedFile
+	order  []string
+	merged *collection
+}
+
+// Watch extracts the metadata of a session that is still being collected. The metadata folders of the dataset
+// and the EPU mirror are polled, new or changed xml and mdoc files are parsed and merged into the session state,
+// which is finished and passed to OnUpdate every WriteInterval. It returns the last result once the session
+// ended (IdleTimeout) or ctx is done, a poll is then interrupted and the files not read yet are left out.
+func Watch(ctx context.Context, topLevelDirectory string, opts Options, watch WatchOptions) (*Result, error) {
+	if watch.PollInterval <= 0 {
+		watch.PollInterval = 10 * time.Second
+	}
+	s, err := newSession(topLevelDirectory, opts)
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		session:     s,
+		states:      make(map[string]fileState),
+		failed:      make(map[string]fileState),
+		diagnostics: make(map[string]Diagnostic),
+		parsed:      make(map[string]parsedFile),
+		merged:      newCollection(s.qualityRules),
+	}
+
+	var last *Result
+	lastChange := time.Now()
+	var lastWrite time.Time
+	dirty := false
+	for {
+		changed, err := w.poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			return last, err
+		}
+		if changed > 0 {
+			s.log.Info("New or changed metadata files", "changed", changed, "files", len(w.order))
+			lastChange = time.Now()
+			dirty = true
+		}
+		// the paths only need explaining once
+		s.opts.ExplainPaths = false
+
+		ended := watch.IdleTimeout > 0 && time.Since(lastChange) >= watch.IdleTimeout
+		if ctx.Err() != nil {
+			ended = true
+		}
+		if ended {
+			result, err := w.write(watch, true)
+			if err != nil {
+				return last, err
+			}
+			return result, nil
+		}
+		if dirty && watch.WriteInterval > 0 && time.Since(lastWrite) >= watch.WriteInterval && len(w.order) > 0 {
+			result, err := w.write(watch, false)
+			if err != nil {
+				return last, err
+			}
+			last, lastWrite, dirty = result, time.Now(), false
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(watch.PollInterval):
+		}
+	}
+}
+
+// write finishes the current state, later writes replace the outputs of the first one
+func (w *watcher) write(watch WatchOptions, final bool) (*Result, error) {
+	w.merged.failed = nil
+	for _, diagnostic := range w.diagnostics {
+		w.merged.failed = append(w.merged.failed, diagnostic)
+	}
+	sortDiagnostics(w.merged.failed)
+	result, err := w.session.finish(w.allfiles, w.merged)
+	if err != nil {
+		return nil, err
+	}
+	w.session.opts.Force = true
+	if watch.OnUpdate != nil {
+		if err := watch.OnUpdate(result, final); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// poll parses the new and changed files and returns how many files were added, changed or removed. New files
+// are merged into the state, changed or removed ones make it merge all files again as their old values can
+// not be taken out.
+func (w *watcher) poll(ctx context.Context) (int, error) {
+	allfiles, err := w.session.discover(ctx)
+	if err != nil {
+		return 0, err
+	}
+	w.allfiles = allfiles
+	present := make(map[string]bool)
+	states := make(map[string]fileState)
+	var fresh []string
+	for _, path := range allfiles {
+		present[path] = true
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if old, seen := w.states[path]; seen && old == state {
+			continue
+		}
+		if old, failed := w.failed[path]; failed && old == state {
+			continue
+		}
+		states[path] = state
+		fresh = append(fresh, path)
+	}
+	changed := 0
+	remerge := false
+	for path := range w.states {
+		if !present[path] {
+			delete(w.states, path)
+			delete(w.parsed, path)
+			remerge = true
+			changed++
+		}
+	}
+	for path := range w.failed {
+		if !present[path] {
+			delete(w.failed, path)
+			delete(w.diagnostics, path)
+		}
+	}
+
+	parsed := make(map[string]parsedFile)
+	if len(fresh) > 0 {
+		w.session.progress.discovered(len(fresh))
+	}
+	failed := w.session.parse(ctx, fresh, func(file parsedFile) {
+		parsed[file.path] = file
+	})
+	for _, diagnostic := range failed {
+		w.diagnostics[diagnostic.Path] = diagnostic
+	}
+	for _, path := range fresh {
+		file, ok := parsed[path]
+		if !ok {
+			// a file left out by the cancellation was not tried
+			if ctx.Err() == nil {
+				w.failed[path] = states[path]
+			}
+			continue
+		}
+		delete(w.failed, path)
+		delete(w.diagnostics, path)
+		changed++
+		if _, seen := w.parsed[path]; seen {
+			remerge = true
+		} else {
+			w.order = append(w.order, path)
+			if !remerge {
+				w.merged.add(file)
+			}
+		}
+		w.parsed[path] = file
+		w.states[path] = states[path]
+	}
+
+	if remerge {
+		var order []string
+		w.merged = newCollection(w.session.qualityRules)
+		for _, path := range w.order {
+			if file, ok := w.parsed[path]; ok {
+				order = append(order, path)
+				w.merged.add(file)
+			}
+		}
+		w.order = order
+	}
+	if changed > 0 {
+		w.session.saveCache(allfiles)
+	}
+	return changed, nil
+}
@@ -0,0 +1,57 @@
+package metadataparser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/pathmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dataset := t.TempDir()
+	data := filepath.Join(dataset, "Images-Disc1", "GridSquare_1", "Data")
+	assert.NoError(t, os.MkdirAll(data, 0755))
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	assert.Len(t, sources, 2)
+	copyFile := func(source string) {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(source)), content, 0644))
+	}
+	copyFile(sources[0])
+	// a file still being written is retried once it changes
+	assert.NoError(t, os.WriteFile(filepath.Join(data, filepath.Base(sources[1])), []byte("<MicroscopeImage"), 0644))
+
+	updates := make(chan string, 100)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	done := make(chan *Result)
+	go func() {
+		result, err := Watch(ctx, dataset, Options{PathMappings: []pathmap.Rule{}, WindowsPaths: []pathmap.WindowsRule{}}, WatchOptions{
+			PollInterval:  10 * time.Millisecond,
+			WriteInterval: time.Nanosecond,
+			OnUpdate: func(result *Result, final bool) error {
+				updates <- result.merged["NumberOfMovies"]
+				return nil
+			},
+		})
+		assert.NoError(t, err)
+		done <- result
+	}()
+
+	assert.Equal(t, "1", <-updates)
+	copyFile(sources[1])
+	assert.Equal(t, "2", <-updates)
+	stop()
+	result := <-done
+	assert.Equal(t, "2", result.merged["NumberOfMovies"])
+
+	// the same files extracted at once give the same metadata
+	once, err := Extract(dataset, Options{PathMappings: []pathmap.Rule{}, WindowsPaths: []pathmap.WindowsRule{}})
+	assert.NoError(t, err)
+	assert.Equal(t, once.merged, result.merged)
+}
@@ -0,0 +1,236 @@
+package metadataparser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xmlValue is an entry of the CustomData of an EPU xml, Type is its i:type as written (b:double, b:string,
+// ...) like the attributes of the other elements, empty if it has none
+type xmlValue struct {
+	Key   string
+	Value string
+	Type  string
+}
+
+// xmlDocument is an EPU MicroscopeImage xml read in one pass
+type xmlDocument struct {
+	// trimmed text of the elements without child elements and the attributes by dotted path, e.g.
+	// MicroscopeImage.name or MicroscopeImage.microscopeData.optics.Apertures.Aperture[1].@nil; repeated
+	// siblings are indexed from 0, the CustomData is only in custom
+	leaves map[string]string
+	// the name and uniqueID of the image, not trimmed
+	name     string
+	uniqueID string
+	custom   []xmlValue
+}
+
+// xmlFrame is an open element while decoding
+type xmlFrame struct {
+	// the node of the element, -1 inside of the CustomData
+	node     int
+	text     []byte
+	children bool
+}
+
+// xmlNode is an element of the document, its path is only known once all its siblings were read
+type xmlNode struct {
+	parent int
+	name   string
+	index  int
+	// number of child elements by name, nil without children
+	counts map[string]int
+}
+
+// xmlLeaf is a value found under a node, attr is empty for the text of the element
+type xmlLeaf struct {
+	node  int
+	attr  string
+	value string
+}
+
+// decodeXML reads a MicroscopeImage xml with a single streaming pass over its tokens. Elements are matched
+// by their local name, whatever their namespace, and only the text directly inside an element counts,
+// as encoding/xml does for unmarshaling. Errors tell where the decoding stopped, see xmlError.
+func decodeXML(r io.Reader) (*xmlDocument, error) {
+	decoder := xml.NewDecoder(r)
+	doc, err := decodeTokens(decoder)
+	if err != nil {
+		return nil, xmlError(err, decoder.InputOffset())
+	}
+	return doc, nil
+}
+
+func decodeTokens(decoder *xml.Decoder) (*xmlDocument, error) {
+	doc := &xmlDocument{}
+	// raw tokens skip the namespace translation, a good part of the decoding time; the end elements are
+	// checked against the open ones here instead
+	var stack []xmlFrame
+	var names []xml.Name
+	var nodes []xmlNode
+	var leaves []xmlLeaf
+	// the CustomData entry being read, -1 outside of one
+	current := -1
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF && len(stack) > 0 {
+			return nil, syntaxError(decoder, "unexpected EOF")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			local := token.Name.Local
+			node := -1
+			if len(stack) > 0 {
+				parent := &stack[len(stack)-1]
+				parent.children = true
+				// the CustomData entries are read below with their types
+				if parent.node >= 0 && !(len(stack) == 1 && local == "CustomData") {
+					node = addNode(&nodes, parent.node, local)
+				}
+			} else if local != "MicroscopeImage" {
+				return nil, fmt.Errorf("expected element type <MicroscopeImage> but have <%s>", local)
+			} else {
+				node = addNode(&nodes, -1, local)
+			}
+			if node >= 0 {
+				leaves = appendAttrs(leaves, node, token.Attr)
+			}
+			// the text buffer of a closed frame is reused
+			var text []byte
+			if len(stack) < cap(stack) {
+				text = stack[:cap(stack)][len(stack)].text[:0]
+			}
+			stack = append(stack, xmlFrame{node: node, text: text})
+			names = append(names, token.Name)
+			if len(stack) == 3 && local == "KeyValueOfstringanyType" && names[1].Local == "CustomData" {
+				doc.custom = append(doc.custom, xmlValue{})
+				current = len(doc.custom) - 1
+			} else if len(stack) == 4 && local == "Value" && current >= 0 {
+				doc.custom[current].Type = valueType(token.Attr)
+			}
+		case xml.EndElement:
+			if len(names) == 0 {
+				return nil, syntaxError(decoder, "unexpected end element </"+token.Name.Local+">")
+			}
+			open := names[len(names)-1]
+			if open.Local != token.Name.Local {
+				return nil, syntaxError(decoder, "element <"+open.Local+"> closed by </"+token.Name.Local+">")
+			}
+			if open.Space != token.Name.Space {
+				return nil, syntaxError(decoder, "element <"+open.Local+"> in space "+open.Space+" closed by </"+token.Name.Local+"> in space "+token.Name.Space)
+			}
+			frame := &stack[len(stack)-1]
+			if !frame.children && frame.node >= 0 {
+				if trimmed := strings.TrimSpace(string(frame.text)); trimmed != "" {
+					leaves = append(leaves, xmlLeaf{node: frame.node, value: trimmed})
+				}
+			}
+			switch {
+			case len(stack) == 2 && open.Local == "name":
+				doc.name = string(frame.text)
+			case len(stack) == 2 && open.Local == "uniqueID":
+				doc.uniqueID = string(frame.text)
+			case len(stack) == 4 && current >= 0 && open.Local == "Key":
+				doc.custom[current].Key = string(frame.text)
+			case len(stack) == 4 && current >= 0 && open.Local == "Value":
+				doc.custom[current].Value = string(frame.text)
+			case len(stack) == 3:
+				current = -1
+			}
+			stack = stack[:len(stack)-1]
+			names = names[:len(names)-1]
+			if len(stack) == 0 {
+				// anything after the root element is ignored, as by xml.Unmarshal
+				doc.leaves = flatten(nodes, leaves)
+				return doc, nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text = append(stack[len(stack)-1].text, token...)
+			}
+		}
+	}
+}
+
+// addNode adds the element name under parent and returns its node
+func addNode(nodes *[]xmlNode, parent int, name string) int {
+	index := 0
+	if parent >= 0 {
+		siblings := &(*nodes)[parent]
+		if siblings.counts == nil {
+			siblings.counts = make(map[string]int)
+		}
+		index = siblings.counts[name]
+		siblings.counts[name]++
+	}
+	*nodes = append(*nodes, xmlNode{parent: parent, name: name, index: index})
+	return len(*nodes) - 1
+}
+
+// appendAttrs adds the attributes of an element by their local name, the namespace declarations are left out
+func appendAttrs(leaves []xmlLeaf, node int, attrs []xml.Attr) []xmlLeaf {
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		leaves = append(leaves, xmlLeaf{node: node, attr: attr.Name.Local, value: attr.Value})
+	}
+	return leaves
+}
+
+// flatten builds the dotted paths of the leaves, an element gets an index only if it has siblings of the
+// same name
+func flatten(nodes []xmlNode, leaves []xmlLeaf) map[string]string {
+	// parents come before their children, so the paths are built in order
+	paths := make([]string, len(nodes))
+	for i, node := range nodes {
+		if node.parent < 0 {
+			paths[i] = node.name
+			continue
+		}
+		paths[i] = paths[node.parent] + "." + node.name
+		if nodes[node.parent].counts[node.name] > 1 {
+			paths[i] += "[" + strconv.Itoa(node.index) + "]"
+		}
+	}
+	flat := make(map[string]string, len(leaves))
+	for _, leaf := range leaves {
+		if leaf.attr != "" {
+			flat[paths[leaf.node]+".@"+leaf.attr] = leaf.value
+		} else {
+			flat[paths[leaf.node]] = leaf.value
+		}
+	}
+	return flat
+}
+
+// valueType is the i:type attribute
+func valueType(attrs []xml.Attr) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == "type" && attr.Name.Space != "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func syntaxError(decoder *xml.Decoder, msg string) error {
+	line, _ := decoder.InputPos()
+	return &xml.SyntaxError{Msg: msg, Line: line}
+}
+
+// dropCustomDataTypes removes the <key>.@type of the CustomData entries. Their keys are those of EPU, the
+// other elements of the xml are below MicroscopeImage.
+func dropCustomDataTypes(merged map[string]string) {
+	for key := range merged {
+		if strings.HasSuffix(key, ".@type") && !strings.HasPrefix(key, "MicroscopeImage.") {
+			delete(merged, key)
+		}
+	}
+}
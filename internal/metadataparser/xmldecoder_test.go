@@ -0,0 +1,204 @@
+package metadataparser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unmarshalXML is the former two pass parsing of process_xml, decodeXML has to give the same name,
+// uniqueID, CustomData and errors; its leaves lost repeated elements and attributes
+
+type referenceImage struct {
+	XMLName    xml.Name `xml:"MicroscopeImage"`
+	Name       string   `xml:"name"`
+	UniqueID   string   `xml:"uniqueID"`
+	CustomData struct {
+		KeyValues []struct {
+			Key   string `xml:"Key"`
+			Value string `xml:"Value"`
+		} `xml:"KeyValueOfstringanyType"`
+	} `xml:"CustomData"`
+}
+
+type referenceElement struct {
+	XMLName  xml.Name
+	Content  string             `xml:",chardata"`
+	Children []referenceElement `xml:",any"`
+}
+
+func (element referenceElement) leaves(path string, leafNodes map[string]string) {
+	if path != "" {
+		path += "." + element.XMLName.Local
+	} else {
+		path = element.XMLName.Local
+	}
+	if trimmed := strings.TrimSpace(element.Content); len(element.Children) == 0 && trimmed != "" {
+		leafNodes[path] = trimmed
+	}
+	for _, child := range element.Children {
+		child.leaves(path, leafNodes)
+	}
+}
+
+func unmarshalXML(data []byte) (map[string]string, error) {
+	var root referenceElement
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	leafNodes := make(map[string]string)
+	root.leaves("", leafNodes)
+	var image referenceImage
+	if err := xml.Unmarshal(data, &image); err != nil {
+		return nil, err
+	}
+	leafNodes["MicroscopeImage.Name"] = image.Name
+	leafNodes["MicroscopeImage.UniqueID"] = image.UniqueID
+	for _, kv := range image.CustomData.KeyValues {
+		leafNodes[kv.Key] = kv.Value
+	}
+	return leafNodes, nil
+}
+
+// custom leaves out the flattened elements, which the reference got wrong
+func custom(leafNodes map[string]string) map[string]string {
+	values := make(map[string]string)
+	for key, value := range leafNodes {
+		if !strings.HasPrefix(key, "MicroscopeImage.") || key == "MicroscopeImage.Name" || key == "MicroscopeImage.UniqueID" {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+func flattenDoc(doc *xmlDocument) map[string]string {
+	flat := make(map[string]string)
+	flat["MicroscopeImage.Name"] = doc.name
+	flat["MicroscopeImage.UniqueID"] = doc.uniqueID
+	for _, kv := range doc.custom {
+		flat[kv.Key] = kv.Value
+	}
+	return flat
+}
+
+func TestDecodeXML(t *testing.T) {
+	inputs := []string{
+		`<?xml version="1.0"?>` + "\n" + `<MicroscopeImage xmlns:i="x"><name> a <!-- c --><![CDATA[b]]></name><x><y>1</y><y>2</y>text</x><z/></MicroscopeImage>trailing`,
+		`<MicroscopeImage><CustomData xmlns:a="a"><a:KeyValueOfstringanyType><a:Key>k</a:Key><a:Value>1<n>2</n></a:Value></a:KeyValueOfstringanyType>` +
+			`<a:KeyValueOfstringanyType><a:Value>no key</a:Value></a:KeyValueOfstringanyType></CustomData><CustomData><KeyValueOfstringanyType><Key>k</Key><Value>3</Value></KeyValueOfstringanyType></CustomData></MicroscopeImage>`,
+		`<MicroscopeImage><uniqueID>u</uniqueID><Key>outside</Key><Other><KeyValueOfstringanyType><Key>not custom</Key></KeyValueOfstringanyType></Other></MicroscopeImage>`,
+	}
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		assert.NoError(t, err)
+		inputs = append(inputs, string(content))
+	}
+	for _, input := range inputs {
+		want, err := unmarshalXML([]byte(input))
+		assert.NoError(t, err)
+		doc, err := decodeXML(strings.NewReader(input))
+		if assert.NoError(t, err) {
+			assert.Equal(t, custom(want), flattenDoc(doc))
+		}
+	}
+
+	for _, input := range []string{``, `<Other/>`, `<MicroscopeImage><a></b></MicroscopeImage>`, `<MicroscopeImage><a:x></b:x></MicroscopeImage>`, `<MicroscopeImage><a>`, `</a>`} {
+		_, want := unmarshalXML([]byte(input))
+		_, err := decodeXML(strings.NewReader(input))
+		if assert.Error(t, err, input) {
+			expected, got := diagnose("x.xml", xmlError(want, 0)), diagnose("x.xml", err)
+			assert.Equal(t, expected.Reason, got.Reason, input)
+			assert.Equal(t, expected.Line, got.Line, input)
+		}
+	}
+}
+
+func TestDecodeXMLLeaves(t *testing.T) {
+	tests := []struct {
+		input  string
+		leaves map[string]string
+	}{
+		{
+			`<MicroscopeImage xmlns:i="x"><name> a </name><x><y>1</y><y i:nil="true"/><y>3</y><z>4</z>text</x></MicroscopeImage>`,
+			map[string]string{"MicroscopeImage.name": "a", "MicroscopeImage.x.y[0]": "1", "MicroscopeImage.x.y[1].@nil": "true", "MicroscopeImage.x.y[2]": "3", "MicroscopeImage.x.z": "4"},
+		},
+		{
+			`<MicroscopeImage><a><b>1</b></a><a><b>2</b><b>3</b></a><c id="c1"><d>4</d></c></MicroscopeImage>`,
+			map[string]string{"MicroscopeImage.a[0].b": "1", "MicroscopeImage.a[1].b[0]": "2", "MicroscopeImage.a[1].b[1]": "3", "MicroscopeImage.c.@id": "c1", "MicroscopeImage.c.d": "4"},
+		},
+		{
+			`<MicroscopeImage><CustomData xmlns:a="a"><a:KeyValueOfstringanyType><a:Key>k</a:Key><a:Value i:type="b:double">1</a:Value></a:KeyValueOfstringanyType>` +
+				`<a:KeyValueOfstringanyType><a:Key>l</a:Key><a:Value>2</a:Value></a:KeyValueOfstringanyType></CustomData><x><CustomData>5</CustomData></x></MicroscopeImage>`,
+			map[string]string{"MicroscopeImage.x.CustomData": "5"},
+		},
+	}
+	for _, test := range tests {
+		doc, err := decodeXML(strings.NewReader(test.input))
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.leaves, doc.leaves, test.input)
+		}
+	}
+}
+
+func TestDecodeXMLTypes(t *testing.T) {
+	content, err := os.ReadFile("../../tests/xml/FoilHole_31933450_Data_31923928_31923930_20240901_060108.xml")
+	assert.NoError(t, err)
+	doc, err := decodeXML(bytes.NewReader(content))
+	assert.NoError(t, err)
+	types := make(map[string]string)
+	for _, kv := range doc.custom {
+		types[kv.Key] = kv.Type
+	}
+	assert.Equal(t, "b:double", types["DoseOnCamera"])
+	assert.Equal(t, "b:boolean", types["StemMagnification"])
+	assert.Equal(t, "b:long", types["Detectors[EF-Falcon].TimeStamp"])
+
+	// process_xml keeps them next to the values
+	leaves, err := process_xml("../../tests/xml/FoilHole_31933450_Data_31923928_31923930_20240901_060108.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, "b:double", leaves["DoseOnCamera.@type"])
+	assert.Equal(t, "b:long", leaves["Detectors[EF-Falcon].TimeStamp.@type"])
+}
+
+func benchmarkXML(b *testing.B, parse func([]byte) error) {
+	sources, _ := filepath.Glob("../../tests/xml/*.xml")
+	var contents [][]byte
+	size := 0
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			b.Fatal(err)
+		}
+		contents = append(contents, content)
+		size += len(content)
+	}
+	b.SetBytes(int64(size / len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := parse(contents[i%len(contents)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeXML(b *testing.B) {
+	benchmarkXML(b, func(content []byte) error {
+		_, err := decodeXML(bytes.NewReader(content))
+		return err
+	})
+}
+
+// BenchmarkUnmarshalXML is the former parsing, for comparison
+func BenchmarkUnmarshalXML(b *testing.B) {
+	benchmarkXML(b, func(content []byte) error {
+		_, err := unmarshalXML(content)
+		return err
+	})
+}
@@ -0,0 +1,185 @@
+// Package pathmap maps a dataset (OffloadData) directory to the directory where EPU mirrors it
+// on the microscope computer and keeps its metadata xmls.
+package pathmap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Rule maps a dataset directory to mirror candidates. With Prefix, directories below it are
+// rewritten to Replace followed by the rest of the path. With Regex, a matching directory is
+// rewritten to Replace with $1, ${name} ... expanded from the capture groups. Without either,
+// the name of the dataset directory is kept, as EPU mirrors OffloadData folders by name.
+// If Roots are given, the rewritten path is joined below each of them, the first existing
+// candidate is used.
+type Rule struct {
+	Prefix  string   `json:"Prefix,omitempty"`
+	Regex   string   `json:"Regex,omitempty"`
+	Replace string   `json:"Replace,omitempty"`
+	Roots   []string `json:"Roots,omitempty"`
+}
+
+// RootRule is the mapping of a plain mirror root like MPCPATH: the dataset name below root
+func RootRule(root string) Rule {
+	return Rule{Roots: []string{root}}
+}
+
+func (rule Rule) String() string {
+	var parts []string
+	if rule.Prefix != "" {
+		parts = append(parts, "prefix "+rule.Prefix)
+	}
+	if rule.Regex != "" {
+		parts = append(parts, "regex "+rule.Regex)
+	}
+	if rule.Replace != "" {
+		parts = append(parts, "-> "+rule.Replace)
+	}
+	if len(rule.Roots) > 0 {
+		parts = append(parts, "below "+strings.Join(rule.Roots, ", "))
+	}
+	if len(parts) == 0 {
+		return "dataset name"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Validate checks that the rule can be applied
+func (rule Rule) Validate() error {
+	if rule.Prefix != "" && rule.Regex != "" {
+		return fmt.Errorf("rule %s: use either Prefix or Regex", rule)
+	}
+	if rule.Regex != "" {
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("rule %s: %w", rule, err)
+		}
+	}
+	if rule.Prefix == "" && rule.Regex == "" && len(rule.Roots) == 0 {
+		return fmt.Errorf("rule needs a Prefix, Regex or Roots")
+	}
+	if rule.Regex == "" && rule.Prefix == "" && rule.Replace != "" {
+		return fmt.Errorf("rule %s: Replace needs a Prefix or Regex", rule)
+	}
+	// the rest of the path alone would be taken relative to the working directory
+	if rule.Prefix != "" && rule.Replace == "" && len(rule.Roots) == 0 {
+		return fmt.Errorf("rule %s: Prefix needs a Replace or Roots", rule)
+	}
+	if rule.Replace != "" && len(rule.Roots) == 0 && !filepath.IsAbs(rule.Replace) {
+		return fmt.Errorf("rule %s: Replace must be an absolute path without Roots", rule)
+	}
+	for _, root := range rule.Roots {
+		if root == "" {
+			return fmt.Errorf("rule %s: empty root", rule)
+		}
+	}
+	return nil
+}
+
+// rewrite applies prefix or regex, false if the rule does not apply to directory
+func (rule Rule) rewrite(directory string) (string, bool, error) {
+	switch {
+	case rule.Prefix != "":
+		prefix := filepath.Clean(rule.Prefix)
+		if directory != prefix && !strings.HasPrefix(directory, strings.TrimSuffix(prefix, string(filepath.Separator))+string(filepath.Separator)) {
+			return "", false, nil
+		}
+		rest := strings.TrimPrefix(strings.TrimPrefix(directory, prefix), string(filepath.Separator))
+		if rule.Replace == "" {
+			return rest, true, nil
+		}
+		return filepath.Join(rule.Replace, rest), true, nil
+	case rule.Regex != "":
+		pattern, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return "", false, err
+		}
+		match := pattern.FindStringSubmatchIndex(directory)
+		if match == nil {
+			return "", false, nil
+		}
+		replace := rule.Replace
+		if replace == "" {
+			replace = "$0"
+		}
+		return string(pattern.ExpandString(nil, replace, directory, match)), true, nil
+	default:
+		return filepath.Base(directory), true, nil
+	}
+}
+
+// Candidate is a possible mirror directory and the rule it comes from
+type Candidate struct {
+	Path   string
+	Rule   Rule
+	Exists bool
+}
+
+// Candidates applies the rules in order to the absolute dataset directory
+func Candidates(rules []Rule, directory string) ([]Candidate, error) {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Candidate
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		rewritten, applies, err := rule.rewrite(directory)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule, err)
+		}
+		if !applies {
+			continue
+		}
+		paths := []string{rewritten}
+		if len(rule.Roots) > 0 {
+			paths = nil
+			for _, root := range rule.Roots {
+				paths = append(paths, filepath.Join(root, rewritten))
+			}
+		}
+		for _, path := range paths {
+			path = filepath.Clean(path)
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			info, err := os.Stat(path)
+			candidates = append(candidates, Candidate{Path: path, Rule: rule, Exists: err == nil && info.IsDir()})
+		}
+	}
+	return candidates, nil
+}
+
+// First returns the first existing candidate
+func First(candidates []Candidate) (Candidate, bool) {
+	for _, candidate := range candidates {
+		if candidate.Exists {
+			return candidate, true
+		}
+	}
+	return Candidate{}, false
+}
+
+// Explain writes which candidates were tried for directory and which one is used
+func Explain(w io.Writer, directory string, candidates []Candidate) {
+	fmt.Fprintf(w, "EPU mirror candidates for %s:\n", directory)
+	if len(candidates) == 0 {
+		fmt.Fprintln(w, "  none, no mapping rule or MPCPATH applies")
+		return
+	}
+	for _, candidate := range candidates {
+		state := "missing"
+		if candidate.Exists {
+			state = "found"
+		}
+		fmt.Fprintf(w, "  %-7s %s (%s)\n", state, candidate.Path, candidate.Rule)
+	}
+	if first, found := First(candidates); found {
+		fmt.Fprintln(w, "using", first.Path)
+	}
+}
@@ -0,0 +1,73 @@
+package pathmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCandidates(t *testing.T) {
+	mirror := t.TempDir()
+	for _, dir := range []string{"krios/2024/session1", "session1", "glacios-epu/session1"} {
+		if err := os.MkdirAll(filepath.Join(mirror, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dataset := "/data/offload/krios/2024/session1"
+
+	tests := []struct {
+		name      string
+		rules     []Rule
+		wantPaths []string
+		wantFirst string
+		wantErr   bool
+	}{
+		{name: "legacy root", rules: []Rule{RootRule(mirror)},
+			wantPaths: []string{mirror + "/session1"}, wantFirst: mirror + "/session1"},
+		{name: "root without trailing slash", rules: []Rule{RootRule(mirror + "/")},
+			wantPaths: []string{mirror + "/session1"}, wantFirst: mirror + "/session1"},
+		{name: "prefix keeps nesting", rules: []Rule{{Prefix: "/data/offload/", Replace: mirror}},
+			wantPaths: []string{mirror + "/krios/2024/session1"}, wantFirst: mirror + "/krios/2024/session1"},
+		{name: "prefix does not apply", rules: []Rule{{Prefix: "/data/off", Replace: mirror}}},
+		{name: "regex with groups", rules: []Rule{{Regex: `^/data/offload/(\w+)/\d+/(?P<session>.+)$`, Replace: mirror + "/glacios-epu/${session}"}},
+			wantPaths: []string{mirror + "/glacios-epu/session1"}, wantFirst: mirror + "/glacios-epu/session1"},
+		{name: "several roots", rules: []Rule{{Prefix: "/data/offload/krios", Roots: []string{"/missing", mirror}}},
+			wantPaths: []string{"/missing/2024/session1", mirror + "/2024/session1"}},
+		{name: "rules in order", rules: []Rule{{Prefix: "/data/offload", Roots: []string{"/missing"}}, RootRule(mirror)},
+			wantPaths: []string{"/missing/krios/2024/session1", mirror + "/session1"}, wantFirst: mirror + "/session1"},
+		{name: "bad regex", rules: []Rule{{Regex: "("}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, err := Candidates(tt.rules, dataset)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var paths []string
+			for _, candidate := range candidates {
+				paths = append(paths, candidate.Path)
+			}
+			assert.Equal(t, tt.wantPaths, paths)
+			first, found := First(candidates)
+			assert.Equal(t, tt.wantFirst != "", found)
+			assert.Equal(t, tt.wantFirst, first.Path)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Rule{Prefix: "/data", Replace: "/mnt/epu"}.Validate())
+	assert.NoError(t, RootRule("/mnt/epu").Validate())
+	assert.Error(t, Rule{Prefix: "/data", Regex: "^/data"}.Validate())
+	assert.Error(t, Rule{Regex: "("}.Validate())
+	assert.Error(t, Rule{Replace: "/mnt/epu"}.Validate())
+	assert.Error(t, Rule{}.Validate())
+	assert.Error(t, Rule{Prefix: "/data"}.Validate())
+	assert.NoError(t, Rule{Prefix: "/data", Roots: []string{"/mnt/epu"}}.Validate())
+	assert.Error(t, Rule{Prefix: "/data", Replace: "epu"}.Validate())
+	assert.NoError(t, Rule{Prefix: "/data", Replace: "epu", Roots: []string{"/mnt"}}.Validate())
+}
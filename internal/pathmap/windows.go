@@ -0,0 +1,61 @@
+package pathmap
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// WindowsRule translates paths recorded on the acquisition computer, below a drive like X:\ or a UNC
+// share like \\server\share, to the POSIX directory the same files are found at on the storage server.
+type WindowsRule struct {
+	From string `json:"From"`
+	To   string `json:"To"`
+}
+
+var windowsRoot = regexp.MustCompile(`^([A-Za-z]:|\\\\[^\\]+\\[^\\]+)`)
+
+// IsWindows tells whether a path was recorded on windows: with a drive letter, as UNC or with backslashes
+func IsWindows(recorded string) bool {
+	return windowsRoot.MatchString(recorded) || strings.Contains(recorded, `\`)
+}
+
+// Validate checks that From is a drive or UNC prefix and To is an absolute POSIX path
+func (rule WindowsRule) Validate() error {
+	if !windowsRoot.MatchString(rule.From) {
+		return fmt.Errorf("%q is neither a drive (X:\\) nor a UNC share (\\\\server\\share)", rule.From)
+	}
+	if !strings.HasPrefix(rule.To, "/") {
+		return fmt.Errorf("%q is not an absolute path", rule.To)
+	}
+	return nil
+}
+
+// slashed uses / as separator and drops a trailing one
+func slashed(windowsPath string) string {
+	return strings.TrimRight(strings.ReplaceAll(windowsPath, `\`, "/"), "/")
+}
+
+// ToPOSIX translates a windows path with the first matching rule, comparing case-insensitively as windows does
+func ToPOSIX(recorded string, rules []WindowsRule) (string, bool) {
+	converted := slashed(strings.TrimSpace(recorded))
+	for _, rule := range rules {
+		from := slashed(rule.From)
+		if len(converted) < len(from) || !strings.EqualFold(converted[:len(from)], from) {
+			continue
+		}
+		rest := converted[len(from):]
+		if rest != "" && rest[0] != '/' {
+			continue
+		}
+		return path.Join(rule.To, rest), true
+	}
+	return "", false
+}
+
+// Base is the file name of a recorded path, with either separator
+func Base(recorded string) string {
+	recorded = strings.TrimSpace(recorded)
+	return recorded[strings.LastIndexAny(recorded, `\/`)+1:]
+}
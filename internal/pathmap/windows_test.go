@@ -0,0 +1,40 @@
+package pathmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToPOSIX(t *testing.T) {
+	rules := []WindowsRule{
+		{From: `X:\Users\BioEMlab`, To: "/mnt/bioem"},
+		{From: `X:\`, To: "/mnt/x"},
+		{From: `\\storage\frames`, To: "/mnt/frames"},
+	}
+	tests := []struct {
+		recorded  string
+		want      string
+		wantFound bool
+	}{
+		{recorded: `X:\Users\BioEMlab\Jarek\Jarek 02052023\raw\agro-1_130_048_-67.0.tif`, want: "/mnt/bioem/Jarek/Jarek 02052023/raw/agro-1_130_048_-67.0.tif", wantFound: true},
+		{recorded: `x:\users\michael\raw\a.tif`, want: "/mnt/x/users/michael/raw/a.tif", wantFound: true},
+		{recorded: `X:\Users\BioEMlabOther\a.tif`, want: "/mnt/x/Users/BioEMlabOther/a.tif", wantFound: true},
+		{recorded: `\\STORAGE\frames\session\a.eer`, want: "/mnt/frames/session/a.eer", wantFound: true},
+		{recorded: `\\storage\other\a.eer`},
+		{recorded: `D:\data\a.tif`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.recorded, func(t *testing.T) {
+			assert.True(t, IsWindows(tt.recorded))
+			got, found := ToPOSIX(tt.recorded, rules)
+			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.False(t, IsWindows("/data/a.tif"))
+	assert.Equal(t, "a.tif", Base(`X:\raw\a.tif`))
+	assert.NoError(t, rules[2].Validate())
+	assert.Error(t, WindowsRule{From: "/data", To: "/mnt"}.Validate())
+	assert.Error(t, WindowsRule{From: `X:\`, To: "mnt"}.Validate())
+}
@@ -0,0 +1,318 @@
+// Package server runs extractions as jobs behind a small REST API, for ingestors on other hosts:
+//
+//	POST /jobs               {"path": "/data/session"} submits a job, answers 202 with the job
+//	GET  /jobs               lists the recent jobs, newest first
+//	GET  /jobs/{id}          status and progress of a job
+//	GET  /jobs/{id}/oscem    the OSC-EM json of a finished job
+//	GET  /jobs/{id}/full     the full metadata json of a finished job
+//
+// Only directories below one of the allowed roots are read.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+)
+
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+// Extractor extracts the dataset in directory and returns the OSC-EM and the full metadata json,
+// counting the files read in progress; it stops once ctx is done
+type Extractor func(ctx context.Context, directory string, progress *metadataparser.ProgressTracker) ([]byte, []byte, error)
+
+// Job is an extraction submitted to the server
+type Job struct {
+	ID        string     `json:"id"`
+	Path      string     `json:"path"`
+	State     string     `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	// files read and found, the total is 0 until the files are found
+	FilesRead  int64 `json:"filesRead"`
+	FilesTotal int64 `json:"filesTotal"`
+
+	progress metadataparser.ProgressTracker
+	oscem    []byte
+	full     []byte
+}
+
+// Server keeps the jobs in memory, the oldest finished ones are dropped beyond keep
+type Server struct {
+	// the allowed roots as given and resolved, a path has to be below one of them before it is looked at
+	given   []string
+	roots   []string
+	extract Extractor
+	// at most this many extractions run at the same time
+	slots chan struct{}
+	keep  int
+	// cancelled by Stop
+	ctx  context.Context
+	stop context.CancelFunc
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// New checks the allowed roots and returns a server running up to workers jobs at the same time
+// and remembering the last keep jobs
+func New(roots []string, extract Extractor, workers int, keep int) (*Server, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no root directory allowed, the server would not read anything")
+	}
+	var given, resolved []string
+	for _, root := range roots {
+		absolute, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		real, err := filepath.EvalSymlinks(absolute)
+		if err != nil {
+			return nil, fmt.Errorf("allowed root %s: %w", root, err)
+		}
+		given = append(given, absolute)
+		resolved = append(resolved, real)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if keep < 1 {
+		keep = 100
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &Server{given: given, roots: resolved, extract: extract, slots: make(chan struct{}, workers), keep: keep, ctx: ctx, stop: stop, jobs: make(map[string]*Job)}, nil
+}
+
+// Stop interrupts the running extractions, they and the queued ones fail
+func (s *Server) Stop() {
+	s.stop()
+}
+
+// Handler serves the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs", s.list)
+	mux.HandleFunc("GET /jobs/{id}", s.status)
+	mux.HandleFunc("GET /jobs/{id}/oscem", s.output)
+	mux.HandleFunc("GET /jobs/{id}/full", s.output)
+	return mux
+}
+
+// below tells if path is root or below it
+func below(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed resolves path, following symlinks, and checks that it is a directory below an allowed root.
+// Paths outside of the roots are forbidden before they are looked at, so the errors tell nothing about them.
+func (s *Server) allowed(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("no path given")
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %s is not absolute", path)
+	}
+	path = filepath.Clean(path)
+	if !below(path, s.given) && !below(path, s.roots) {
+		return "", errForbidden
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	// a symlink below a root may lead out of it
+	if !below(real, s.roots) {
+		return "", errForbidden
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+	return real, nil
+}
+
+var errForbidden = errors.New("path is not below an allowed root directory")
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	_ = encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func newID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Path string `json:"path"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	directory, err := s.allowed(request.Path)
+	if errors.Is(err, errForbidden) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job := &Job{ID: newID(), Path: directory, State: StateQueued, Submitted: time.Now().UTC()}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.prune()
+	snapshot := s.snapshot(job)
+	s.mu.Unlock()
+	go s.run(job)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (s *Server) run(job *Job) {
+	// a job still queued when the server stops fails without running
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		finished := time.Now().UTC()
+		job.State, job.Error, job.Finished = StateFailed, s.ctx.Err().Error(), &finished
+		return
+	}
+	defer func() { <-s.slots }()
+	s.mu.Lock()
+	started := time.Now().UTC()
+	job.State, job.Started = StateRunning, &started
+	s.mu.Unlock()
+
+	oscem, full, err := s.extract(s.ctx, job.Path, &job.progress)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	if err != nil {
+		job.State, job.Error = StateFailed, err.Error()
+		return
+	}
+	job.State, job.oscem, job.full = StateDone, oscem, full
+}
+
+// prune drops the oldest finished jobs beyond keep, running and queued ones are kept
+func (s *Server) prune() {
+	if len(s.jobs) <= s.keep {
+		return
+	}
+	var finished []*Job
+	for _, job := range s.jobs {
+		if job.State == StateDone || job.State == StateFailed {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Submitted.Before(finished[j].Submitted) })
+	for _, job := range finished {
+		if len(s.jobs) <= s.keep {
+			break
+		}
+		delete(s.jobs, job.ID)
+	}
+}
+
+// snapshot copies the job with its current progress, the caller holds the lock
+func (s *Server) snapshot(job *Job) Job {
+	return Job{
+		ID: job.ID, Path: job.Path, State: job.State, Error: job.Error,
+		Submitted: job.Submitted, Started: job.Started, Finished: job.Finished,
+		FilesRead:  atomic.LoadInt64(&job.progress.Completed),
+		FilesTotal: atomic.LoadInt64(&job.progress.Total),
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := []Job{}
+	for _, job := range s.jobs {
+		jobs = append(jobs, s.snapshot(job))
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Submitted.After(jobs[j].Submitted) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// the responses are written without the lock, a slow client does not hold up the other requests
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, exists := s.jobs[r.PathValue("id")]
+	var snapshot Job
+	if exists {
+		snapshot = s.snapshot(job)
+	}
+	s.mu.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+func (s *Server) output(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, exists := s.jobs[r.PathValue("id")]
+	if !exists {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+		return
+	}
+	state, content := job.State, job.oscem
+	if strings.HasSuffix(r.URL.Path, "/full") {
+		content = job.full
+	}
+	s.mu.Unlock()
+	if state != StateDone {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s is %s", job.ID, state))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(content)
+}
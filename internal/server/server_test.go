@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osc-em/oscem-extractor-life/internal/metadataparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	dataset := filepath.Join(root, "session")
+	assert.NoError(t, os.Mkdir(dataset, 0755))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+
+	release := make(chan struct{})
+	extract := func(ctx context.Context, directory string, progress *metadataparser.ProgressTracker) ([]byte, []byte, error) {
+		atomic.StoreInt64(&progress.Total, 2)
+		atomic.StoreInt64(&progress.Completed, 1)
+		<-release
+		atomic.StoreInt64(&progress.Completed, 2)
+		return []byte(`{"oscem":"` + filepath.Base(directory) + `"}`), []byte(`{"full":1}`), nil
+	}
+	_, err := New(nil, extract, 1, 10)
+	assert.Error(t, err)
+	jobs, err := New([]string{root}, extract, 1, 10)
+	assert.NoError(t, err)
+	api := httptest.NewServer(jobs.Handler())
+	defer api.Close()
+
+	submit := func(body string) (*http.Response, Job) {
+		response, err := http.Post(api.URL+"/jobs", "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		var job Job
+		_ = json.NewDecoder(response.Body).Decode(&job)
+		return response, job
+	}
+	get := func(path string) (int, string) {
+		response, err := http.Get(api.URL + path)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"path": "` + outside + `"}`, status: http.StatusForbidden},
+		{body: `{"path": "` + filepath.Join(root, "escape") + `"}`, status: http.StatusForbidden},
+		{body: `{"path": "` + filepath.Join(root, "session", "..", "..") + `"}`, status: http.StatusForbidden},
+		{body: `{"path": "session"}`, status: http.StatusBadRequest},
+		{body: `{"path": "` + filepath.Join(root, "missing") + `"}`, status: http.StatusBadRequest},
+		// nothing is told about paths outside of the roots, whether they exist or not
+		{body: `{"path": "` + filepath.Join(outside, "missing") + `"}`, status: http.StatusForbidden},
+		{body: `{"path": "/proc/self/root/nonexistent"}`, status: http.StatusForbidden},
+		{body: `{"dir": "/"}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		response, _ := submit(tt.body)
+		assert.Equal(t, tt.status, response.StatusCode, tt.body)
+	}
+
+	response, job := submit(`{"path": "` + dataset + `"}`)
+	assert.Equal(t, http.StatusAccepted, response.StatusCode)
+	assert.Equal(t, "/jobs/"+job.ID, response.Header.Get("Location"))
+	waitFor := func(state string) Job {
+		for i := 0; i < 200; i++ {
+			_, body := get("/jobs/" + job.ID)
+			var current Job
+			assert.NoError(t, json.Unmarshal([]byte(body), &current))
+			if current.State == state {
+				return current
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("job never got %s", state)
+		return Job{}
+	}
+	running := waitFor(StateRunning)
+	assert.Equal(t, int64(1), running.FilesRead)
+	assert.Equal(t, int64(2), running.FilesTotal)
+	status, _ := get("/jobs/" + job.ID + "/oscem")
+	assert.Equal(t, http.StatusConflict, status)
+
+	close(release)
+	done := waitFor(StateDone)
+	assert.Equal(t, int64(2), done.FilesRead)
+	status, body := get("/jobs/" + job.ID + "/oscem")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"oscem":"session"}`, body)
+	_, body = get("/jobs/" + job.ID + "/full")
+	assert.Equal(t, `{"full":1}`, body)
+
+	status, body = get("/jobs")
+	assert.Equal(t, http.StatusOK, status)
+	var listed []Job
+	assert.NoError(t, json.Unmarshal([]byte(body), &listed))
+	assert.Len(t, listed, 1)
+	status, _ = get("/jobs/unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestServerStop(t *testing.T) {
+	root := t.TempDir()
+	// the running job keeps its slot until it is released
+	release := make(chan struct{})
+	extract := func(ctx context.Context, directory string, progress *metadataparser.ProgressTracker) ([]byte, []byte, error) {
+		<-release
+		return nil, nil, ctx.Err()
+	}
+	jobs, err := New([]string{root}, extract, 1, 10)
+	assert.NoError(t, err)
+	running := &Job{ID: "running", Path: root, State: StateQueued}
+	queued := &Job{ID: "queued", Path: root, State: StateQueued}
+	jobs.jobs[running.ID], jobs.jobs[queued.ID] = running, queued
+	done := make(chan string, 2)
+	for _, job := range []*Job{running, queued} {
+		go func() {
+			jobs.run(job)
+			done <- job.ID
+		}()
+		for job == running {
+			jobs.mu.Lock()
+			state := running.State
+			jobs.mu.Unlock()
+			if state == StateRunning {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// the queued job does not wait for the slot once the server stops
+	jobs.Stop()
+	select {
+	case id := <-done:
+		assert.Equal(t, "queued", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued job still waits for a slot")
+	}
+	close(release)
+	assert.Equal(t, "running", <-done)
+	for _, job := range []*Job{running, queued} {
+		assert.Equal(t, StateFailed, job.State, job.ID)
+		assert.Equal(t, context.Canceled.Error(), job.Error, job.ID)
+	}
+}